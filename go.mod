@@ -5,19 +5,23 @@ go 1.13
 require (
 	github.com/RichardKnop/machinery v1.8.5
 	github.com/desertbit/timer v0.0.0-20180107155436-c41aec40b27f // indirect
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-ozzo/ozzo-validation/v4 v4.2.1
+	github.com/golang/protobuf v1.4.2
 	github.com/google/uuid v1.1.1
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/grpc-ecosystem/go-grpc-middleware v1.2.0
 	github.com/improbable-eng/grpc-web v0.12.0
 	github.com/joho/godotenv v1.3.0
 	github.com/mwitkow/go-conntrack v0.0.0-20190716064945-2f068394615f // indirect
 	github.com/pkg/errors v0.9.1
-	github.com/rs/cors v1.7.0 // indirect
+	github.com/prometheus/client_golang v1.7.1
+	github.com/rs/cors v1.8.0
 	github.com/rs/zerolog v1.18.0
 	github.com/stretchr/testify v1.6.1
 	github.com/valyala/fasthttp v1.15.1
+	google.golang.org/genproto v0.0.0-20200303153909-beee998c1893
 	google.golang.org/grpc v1.29.1
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/errgo.v2 v2.1.0
 )
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+const MetricsPath = "/metrics"
+
+// Serves all metrics collected by gatherer in the prometheus exposition format.
+// Use prometheus.DefaultGatherer when collectors were registered with the default registry
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
+
+// Blocking call serving the metrics of gatherer on :port/metrics
+func RunServer(port string, gatherer prometheus.Gatherer) error {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, Handler(gatherer))
+
+	log.Info().Msgf("Listening to :%s for metrics requests", port)
+
+	return http.ListenAndServe(":"+port, mux)
+}
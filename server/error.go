@@ -55,6 +55,7 @@ const (
 	StatusCode_PreconditionRequired          StatusCode = 428
 	StatusCode_TooManyRequests               StatusCode = 429
 	StatusCode_RequestHeaderFieldsTooLarge   StatusCode = 431
+	StatusCode_ClientClosedRequest           StatusCode = 499 // non standard, the client canceled the request
 	StatusCode_InternalServerError           StatusCode = 500
 	StatusCode_NotImplemented                StatusCode = 501
 	StatusCode_BadGateway                    StatusCode = 502
@@ -68,10 +69,21 @@ const (
 	StatusCode_NetworkAuthenticationRequired StatusCode = 511
 )
 
+// Message sent to clients instead of the message of 5xx errors, which may leak internal details
+const SeriousErrorMessage = "a serious error occurred. if the issue persists, please contact the site administrator."
+
 type Error struct {
 	Message    string
 	StatusCode StatusCode
 	Error      error
+
+	// Optional details sent to the client, see error_details.go
+	RetryInfo           *RetryInfo
+	QuotaFailure        *QuotaFailure
+	ErrorInfo           *ErrorInfo
+	PreconditionFailure *PreconditionFailure
+	BadRequest          *BadRequest
+	LocalizedMessage    *LocalizedMessage
 }
 
 func NewErrorf(statusCode StatusCode, message string, args ...interface{}) *Error {
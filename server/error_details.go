@@ -0,0 +1,120 @@
+package server
+
+import "time"
+
+// Typed details of an Error, modeled after google.rpc error details so every server implementation can
+// render them. Set them with the With* methods of Error
+
+// Tells the client to wait before retrying the request
+type RetryInfo struct {
+	RetryDelay time.Duration
+}
+
+type QuotaViolation struct {
+	// The subject on which the quota check failed, ex: "project:my-project"
+	Subject     string
+	Description string
+}
+
+// A quota check failed, ex: the daily build minutes are exceeded
+type QuotaFailure struct {
+	Violations []QuotaViolation
+}
+
+// Machine readable cause of the error
+type ErrorInfo struct {
+	// UPPER_SNAKE_CASE reason unique within the domain, ex: "BUILD_MINUTES_EXCEEDED"
+	Reason string
+	// The service generating the error, ex: "billing.kintohub.com"
+	Domain   string
+	Metadata map[string]string
+}
+
+type PreconditionViolation struct {
+	// Service specific type of the precondition, ex: "TOS"
+	Type        string
+	Subject     string
+	Description string
+}
+
+// The system is not in a state required for the request, ex: terms of service not accepted
+type PreconditionFailure struct {
+	Violations []PreconditionViolation
+}
+
+type FieldViolation struct {
+	// Dot separated path of the field, ex: "address.city"
+	Field       string
+	Description string
+}
+
+// The request has invalid fields
+type BadRequest struct {
+	FieldViolations []FieldViolation
+}
+
+// Error message safe to show to the end user
+type LocalizedMessage struct {
+	// BCP-47 locale, ex: "en-US"
+	Locale  string
+	Message string
+}
+
+func (e *Error) WithRetryInfo(retryDelay time.Duration) *Error {
+	e.RetryInfo = &RetryInfo{RetryDelay: retryDelay}
+	return e
+}
+
+func (e *Error) WithQuotaViolation(subject, description string) *Error {
+	if e.QuotaFailure == nil {
+		e.QuotaFailure = &QuotaFailure{}
+	}
+
+	e.QuotaFailure.Violations = append(e.QuotaFailure.Violations, QuotaViolation{
+		Subject:     subject,
+		Description: description,
+	})
+	return e
+}
+
+func (e *Error) WithErrorInfo(reason, domain string, metadata map[string]string) *Error {
+	e.ErrorInfo = &ErrorInfo{
+		Reason:   reason,
+		Domain:   domain,
+		Metadata: metadata,
+	}
+	return e
+}
+
+func (e *Error) WithPreconditionViolation(violationType, subject, description string) *Error {
+	if e.PreconditionFailure == nil {
+		e.PreconditionFailure = &PreconditionFailure{}
+	}
+
+	e.PreconditionFailure.Violations = append(e.PreconditionFailure.Violations, PreconditionViolation{
+		Type:        violationType,
+		Subject:     subject,
+		Description: description,
+	})
+	return e
+}
+
+func (e *Error) WithFieldViolation(field, description string) *Error {
+	if e.BadRequest == nil {
+		e.BadRequest = &BadRequest{}
+	}
+
+	e.BadRequest.FieldViolations = append(e.BadRequest.FieldViolations, FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+	return e
+}
+
+func (e *Error) WithLocalizedMessage(locale, message string) *Error {
+	e.LocalizedMessage = &LocalizedMessage{
+		Locale:  locale,
+		Message: message,
+	}
+	return e
+}
@@ -0,0 +1,331 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/kintohub/utils-go/server"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type contextClaimsKey struct{}
+
+// Set on the calls of public methods so the Authorizer lets them through
+type contextPublicMethodKey struct{}
+
+// Methods of the health service are always public so probes do not need a token
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// Claims of a validated jwt, injected into the context of authenticated calls
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Id        string   `json:"jti,omitempty"`
+	// Space separated OAuth2 scopes
+	Scope string   `json:"scope,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// The jwt aud claim which can either be a single string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+	return nil
+}
+
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Implements jwt.Claims. The expiration is mandatory
+func (c *Claims) Valid() error {
+	now := time.Now().Unix()
+
+	if c.ExpiresAt == 0 {
+		return errors.New("token has no expiration")
+	}
+
+	if now >= c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+
+	if c.NotBefore != 0 && now < c.NotBefore {
+		return errors.New("token is not valid yet")
+	}
+
+	return nil
+}
+
+// Returns the claims injected by the authentication interceptor. Not found for public methods
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextClaimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// Configures an Authenticator
+type AuthOption func(a *Authenticator) error
+
+// Validates the bearer jwt of incoming calls. Use WithAuthenticator to add it to a Server
+type Authenticator struct {
+	hmacKeys      map[string][]byte
+	rsaKeys       map[string]*rsa.PublicKey
+	audience      string
+	issuer        string
+	publicMethods map[string]bool
+}
+
+func NewAuthenticator(options ...AuthOption) (*Authenticator, error) {
+	a := &Authenticator{
+		hmacKeys:      map[string][]byte{},
+		rsaKeys:       map[string]*rsa.PublicKey{},
+		publicMethods: map[string]bool{},
+	}
+
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.hmacKeys) == 0 && len(a.rsaKeys) == 0 {
+		return nil, errors.New("at least one key is required to validate tokens")
+	}
+
+	return a, nil
+}
+
+// Accepts HS256 tokens signed with secret. kid must match the kid header of the tokens and can be
+// left empty when tokens have none
+func WithHMACSecret(kid string, secret []byte) AuthOption {
+	return func(a *Authenticator) error {
+		a.hmacKeys[kid] = secret
+		return nil
+	}
+}
+
+// Accepts RS256 tokens signed by the private key of key. See WithHMACSecret for kid
+func WithRSAPublicKey(kid string, key *rsa.PublicKey) AuthOption {
+	return func(a *Authenticator) error {
+		a.rsaKeys[kid] = key
+		return nil
+	}
+}
+
+// Accepts RS256 tokens signed by the private key of the PEM encoded public key in path
+func WithRSAPublicKeyFile(kid, path string) AuthOption {
+	return func(a *Authenticator) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return errors.Wrapf(err, "invalid rsa public key in %s", path)
+		}
+
+		a.rsaKeys[kid] = key
+		return nil
+	}
+}
+
+// Loads the RSA (RS256) and symmetric (HS256) keys of a JSON Web Key Set file
+func WithJWKSFile(path string) AuthOption {
+	return func(a *Authenticator) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var jwks struct {
+			Keys []struct {
+				Kty string `json:"kty"`
+				Kid string `json:"kid"`
+				N   string `json:"n"`
+				E   string `json:"e"`
+				K   string `json:"k"`
+			} `json:"keys"`
+		}
+
+		if err := json.Unmarshal(data, &jwks); err != nil {
+			return errors.Wrapf(err, "invalid jwks file %s", path)
+		}
+
+		for _, key := range jwks.Keys {
+			switch key.Kty {
+			case "RSA":
+				n, err := base64.RawURLEncoding.DecodeString(key.N)
+				if err != nil {
+					return errors.Wrapf(err, "invalid modulus of key %s", key.Kid)
+				}
+
+				e, err := base64.RawURLEncoding.DecodeString(key.E)
+				if err != nil {
+					return errors.Wrapf(err, "invalid exponent of key %s", key.Kid)
+				}
+
+				a.rsaKeys[key.Kid] = &rsa.PublicKey{
+					N: new(big.Int).SetBytes(n),
+					E: int(new(big.Int).SetBytes(e).Int64()),
+				}
+			case "oct":
+				secret, err := base64.RawURLEncoding.DecodeString(key.K)
+				if err != nil {
+					return errors.Wrapf(err, "invalid secret of key %s", key.Kid)
+				}
+
+				a.hmacKeys[key.Kid] = secret
+			}
+		}
+
+		return nil
+	}
+}
+
+// Requires the aud claim of tokens to contain audience
+func WithAudience(audience string) AuthOption {
+	return func(a *Authenticator) error {
+		a.audience = audience
+		return nil
+	}
+}
+
+// Requires the iss claim of tokens to be issuer
+func WithIssuer(issuer string) AuthOption {
+	return func(a *Authenticator) error {
+		a.issuer = issuer
+		return nil
+	}
+}
+
+// Full method names (ex: /kkc.AuthService/Login) that can be called without a token
+func WithPublicMethods(fullMethods ...string) AuthOption {
+	return func(a *Authenticator) error {
+		for _, method := range fullMethods {
+			a.publicMethods[method] = true
+		}
+		return nil
+	}
+}
+
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, ConvertToGrpcError(ctx, err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return ConvertToGrpcError(ctx, err)
+		}
+
+		return handler(srv, &grpc_middleware.WrappedServerStream{
+			ServerStream:   ss,
+			WrappedContext: ctx,
+		})
+	}
+}
+
+// Returns ctx with the claims of the bearer token of the call
+func (a *Authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, *server.Error) {
+	if a.publicMethods[fullMethod] || strings.HasPrefix(fullMethod, healthMethodPrefix) {
+		return context.WithValue(ctx, contextPublicMethodKey{}, true), nil
+	}
+
+	token, err := GetAuthBearerTokenFromHeader(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	if token == "" {
+		return ctx, server.NewError(server.StatusCode_Unauthorized, "missing authorization bearer token")
+	}
+
+	claims, validationErr := a.ValidateToken(token)
+	if validationErr != nil {
+		return ctx, server.NewErrorWithErr(server.StatusCode_Unauthorized, "invalid authorization token", validationErr)
+	}
+
+	return context.WithValue(ctx, contextClaimsKey{}, claims), nil
+}
+
+// Verifies the signature, expiration, audience and issuer of token and returns its claims
+func (a *Authenticator) ValidateToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name, jwt.SigningMethodRS256.Name}}
+
+	_, err := parser.ParseWithClaims(token, claims, a.getKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", claims.Issuer)
+	}
+
+	if a.audience != "" && !containsString(claims.Audience, a.audience) {
+		return nil, fmt.Errorf("token is not intended for audience %s", a.audience)
+	}
+
+	return claims, nil
+}
+
+// Picks the key by kid and by algorithm so a public RSA key can never be used as an HMAC secret
+func (a *Authenticator) getKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	switch token.Method {
+	case jwt.SigningMethodHS256:
+		if key, ok := a.hmacKeys[kid]; ok {
+			return key, nil
+		}
+	case jwt.SigningMethodRS256:
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no %s key found for kid %q", token.Method.Alg(), kid)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
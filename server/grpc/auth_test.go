@@ -0,0 +1,118 @@
+package grpc_test
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcStatus "google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("secret")
+
+func signToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestAuthenticator_UnaryServerInterceptor(t *testing.T) {
+	authenticator, err := kintoGrpc.NewAuthenticator(
+		kintoGrpc.WithHMACSecret("", testSecret),
+		kintoGrpc.WithAudience("kinto"),
+		kintoGrpc.WithIssuer("https://auth.kintohub.com"),
+		kintoGrpc.WithPublicMethods("/kkc.AuthService/Login"),
+	)
+	assert.NoError(t, err)
+
+	validClaims := jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://auth.kintohub.com",
+		"aud":   []string{"kinto", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+		"roles": []string{"admin"},
+	}
+
+	withClaims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range validClaims {
+			claims[k] = v
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		wantCode grpcCodes.Code
+	}{
+		{name: "valid token", method: "/kkc.Service/Get", token: signToken(t, validClaims), wantCode: grpcCodes.OK},
+		{name: "public method", method: "/kkc.AuthService/Login", wantCode: grpcCodes.OK},
+		{name: "missing token", method: "/kkc.Service/Get", wantCode: grpcCodes.Unauthenticated},
+		{
+			name:     "expired token",
+			method:   "/kkc.Service/Get",
+			token:    signToken(t, withClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+			wantCode: grpcCodes.Unauthenticated,
+		},
+		{
+			name:     "no expiration",
+			method:   "/kkc.Service/Get",
+			token:    signToken(t, withClaims(jwt.MapClaims{"exp": nil})),
+			wantCode: grpcCodes.Unauthenticated,
+		},
+		{
+			name:     "wrong audience",
+			method:   "/kkc.Service/Get",
+			token:    signToken(t, withClaims(jwt.MapClaims{"aud": "other"})),
+			wantCode: grpcCodes.Unauthenticated,
+		},
+		{
+			name:     "wrong issuer",
+			method:   "/kkc.Service/Get",
+			token:    signToken(t, withClaims(jwt.MapClaims{"iss": "https://evil.com"})),
+			wantCode: grpcCodes.Unauthenticated,
+		},
+		{name: "garbage token", method: "/kkc.Service/Get", token: "abc", wantCode: grpcCodes.Unauthenticated},
+	}
+
+	interceptor := authenticator.UnaryServerInterceptor()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.token != "" {
+				md.Set("authorization", "Bearer "+tt.token)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					claims, ok := kintoGrpc.ClaimsFromContext(ctx)
+					if tt.token != "" {
+						assert.True(t, ok)
+						assert.Equal(t, "user-1", claims.Subject)
+						assert.Equal(t, []string{"read", "write"}, claims.Scopes())
+						assert.Equal(t, []string{"admin"}, claims.Roles)
+					}
+					return nil, nil
+				})
+
+			assert.Equal(t, tt.wantCode, grpcStatus.Code(err))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kintohub/utils-go/server"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"strings"
+)
+
+// Key of AuthorizationPolicies holding the policy of methods without their own policy
+const DefaultPolicyKey = "*"
+
+// Requirements for calling a method. Callers must have all the scopes and, when roles are set, at least one of them
+type Policy struct {
+	Scopes []string `json:"scopes,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// Policies by full method name (ex: /kkc.ProjectService/DeleteProject). Methods without a policy are only
+// authenticated, unless a default policy is set under DefaultPolicyKey
+type AuthorizationPolicies map[string]*Policy
+
+// Loads policies from a json file, ex:
+// {"/kkc.ProjectService/DeleteProject": {"scopes": ["projects:write"], "roles": ["owner", "admin"]}}
+func LoadAuthorizationPoliciesFromFile(path string) (AuthorizationPolicies, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := AuthorizationPolicies{}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, errors.Wrapf(err, "invalid authorization policies file %s", path)
+	}
+
+	for method, policy := range policies {
+		if policy == nil {
+			return nil, errors.Errorf("invalid authorization policies file %s: the policy of %s is null", path, method)
+		}
+	}
+
+	return policies, nil
+}
+
+// Enforces AuthorizationPolicies using the claims injected by the Authenticator. The public methods of the
+// Authenticator are never authorized. Use WithAuthorizer to add it to a Server
+type Authorizer struct {
+	policies AuthorizationPolicies
+}
+
+func NewAuthorizer(policies AuthorizationPolicies) *Authorizer {
+	return &Authorizer{policies: policies}
+}
+
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		if err := a.authorize(ctx); err != nil {
+			return nil, ConvertToGrpcError(ctx, err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authorize(ss.Context()); err != nil {
+			return ConvertToGrpcError(ss.Context(), err)
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func (a *Authorizer) authorize(ctx context.Context) *server.Error {
+	method, _ := ctx.Value(ContextMethodNameKey).(string)
+
+	// the public methods of the Authenticator can be called without a token, so no policy can apply
+	isPublic, _ := ctx.Value(contextPublicMethodKey{}).(bool)
+	if isPublic || strings.HasPrefix(method, healthMethodPrefix) {
+		return nil
+	}
+
+	policy, ok := a.policies[method]
+	if !ok {
+		policy, ok = a.policies[DefaultPolicyKey]
+	}
+
+	if !ok || policy == nil {
+		return nil
+	}
+
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return server.NewError(server.StatusCode_Unauthorized, "authentication is required")
+	}
+
+	scopes := claims.Scopes()
+	for _, scope := range policy.Scopes {
+		if !containsString(scopes, scope) {
+			return server.NewErrorf(server.StatusCode_Forbidden, "missing required scope %s", scope)
+		}
+	}
+
+	if len(policy.Roles) == 0 {
+		return nil
+	}
+
+	for _, role := range policy.Roles {
+		if containsString(claims.Roles, role) {
+			return nil
+		}
+	}
+
+	return server.NewErrorf(server.StatusCode_Forbidden, "requires one of the roles %v", policy.Roles)
+}
@@ -0,0 +1,134 @@
+package grpc_test
+
+import (
+	"context"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcStatus "google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAuthorizer_UnaryServerInterceptor(t *testing.T) {
+	file, err := ioutil.TempFile("", "policies*.json")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{
+		"/kkc.ProjectService/DeleteProject": {"scopes": ["projects:write"], "roles": ["owner", "admin"]},
+		"/kkc.ProjectService/GetProject": {"scopes": ["projects:read"]}
+	}`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	policies, err := kintoGrpc.LoadAuthorizationPoliciesFromFile(file.Name())
+	assert.NoError(t, err)
+
+	authenticator, err := kintoGrpc.NewAuthenticator(kintoGrpc.WithHMACSecret("", testSecret))
+	assert.NoError(t, err)
+
+	interceptor := grpc_middleware.ChainUnaryServer(
+		authenticator.UnaryServerInterceptor(),
+		kintoGrpc.NewAuthorizer(policies).UnaryServerInterceptor(),
+	)
+
+	tests := []struct {
+		name     string
+		method   string
+		scope    string
+		roles    []string
+		wantCode grpcCodes.Code
+	}{
+		{name: "allowed", method: "/kkc.ProjectService/DeleteProject", scope: "projects:read projects:write",
+			roles: []string{"admin"}, wantCode: grpcCodes.OK},
+		{name: "missing scope", method: "/kkc.ProjectService/DeleteProject", scope: "projects:read",
+			roles: []string{"admin"}, wantCode: grpcCodes.PermissionDenied},
+		{name: "missing role", method: "/kkc.ProjectService/DeleteProject", scope: "projects:write",
+			roles: []string{"member"}, wantCode: grpcCodes.PermissionDenied},
+		{name: "scope only", method: "/kkc.ProjectService/GetProject", scope: "projects:read",
+			wantCode: grpcCodes.OK},
+		{name: "no policy", method: "/kkc.ProjectService/ListProjects", wantCode: grpcCodes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, map[string]interface{}{
+				"exp":   9999999999,
+				"scope": tt.scope,
+				"roles": tt.roles,
+			})
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+			ctx = context.WithValue(ctx, kintoGrpc.ContextMethodNameKey, tt.method)
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, nil
+				})
+
+			assert.Equal(t, tt.wantCode, grpcStatus.Code(err))
+		})
+	}
+}
+
+func TestAuthorizer_DefaultPolicyAndPublicMethods(t *testing.T) {
+	authenticator, err := kintoGrpc.NewAuthenticator(
+		kintoGrpc.WithHMACSecret("", testSecret),
+		kintoGrpc.WithPublicMethods("/kkc.AuthService/Login"),
+	)
+	assert.NoError(t, err)
+
+	interceptor := grpc_middleware.ChainUnaryServer(
+		authenticator.UnaryServerInterceptor(),
+		kintoGrpc.NewAuthorizer(kintoGrpc.AuthorizationPolicies{
+			kintoGrpc.DefaultPolicyKey: {Scopes: []string{"api"}},
+		}).UnaryServerInterceptor(),
+	)
+
+	// lacks the api scope of the default policy
+	token := signToken(t, map[string]interface{}{"exp": 9999999999, "scope": "projects:read"})
+
+	tests := []struct {
+		name     string
+		method   string
+		md       metadata.MD
+		wantCode grpcCodes.Code
+	}{
+		{name: "public method without token", method: "/kkc.AuthService/Login", md: metadata.MD{},
+			wantCode: grpcCodes.OK},
+		{name: "default policy", method: "/kkc.ProjectService/GetProject",
+			md: metadata.Pairs("authorization", "Bearer "+token), wantCode: grpcCodes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			ctx = context.WithValue(ctx, kintoGrpc.ContextMethodNameKey, tt.method)
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, nil
+				})
+
+			assert.Equal(t, tt.wantCode, grpcStatus.Code(err))
+		})
+	}
+}
+
+func TestLoadAuthorizationPoliciesFromFile_NullPolicy(t *testing.T) {
+	file, err := ioutil.TempFile("", "policies*.json")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{"/kkc.ProjectService/DeleteProject": null}`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	_, err = kintoGrpc.LoadAuthorizationPoliciesFromFile(file.Name())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the policy of /kkc.ProjectService/DeleteProject is null")
+}
@@ -2,29 +2,67 @@ package grpc
 
 import (
 	"context"
+	"github.com/google/uuid"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 const (
 	// The key used to insert the method name of the grpc call into context
 	ContextMethodNameKey = "method"
+	// The key used to insert the request id of the grpc call into context, see RequestIDFromContext
+	ContextRequestIdKey = "requestId"
+
+	// Metadata header carrying the request id, see WithRequestIdHeader
+	DefaultRequestIdHeader = "x-request-id"
+
+	// longer incoming ids are replaced so clients cannot flood the logs
+	maxRequestIdLength = 128
 )
 
-// Currently enriches grpc call with method name only atm
-func unaryEnrichCallInterceptor(
-	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
-	resp interface{}, err error) {
+// Id of the request handled by ctx, the incoming request id header or a new uuid when missing.
+// Empty outside of grpc calls
+func RequestIDFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(ContextRequestIdKey).(string)
+	return requestId
+}
+
+// Enriches grpc calls with the method name and request id. The request id is also sent back as a response header
+// and trailer, the trailer reaches clients even when the headers are not sent, ex: trailers-only error responses
+func newUnaryEnrichCallInterceptor(requestIdHeader string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		resp interface{}, err error) {
+		requestId := getIncomingRequestId(ctx, requestIdHeader)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIdHeader, requestId))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(requestIdHeader, requestId))
 
-	return handler(context.WithValue(ctx, ContextMethodNameKey, info.FullMethod), req)
+		ctx = context.WithValue(ctx, ContextMethodNameKey, info.FullMethod)
+		return handler(context.WithValue(ctx, ContextRequestIdKey, requestId), req)
+	}
 }
 
-// Currently enriches grpc call with method name only atm
-func streamEnrichCallInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
-	handler grpc.StreamHandler) error {
+// Stream counterpart of newUnaryEnrichCallInterceptor
+func newStreamEnrichCallInterceptor(requestIdHeader string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		requestId := getIncomingRequestId(ss.Context(), requestIdHeader)
+		_ = ss.SetHeader(metadata.Pairs(requestIdHeader, requestId))
+		ss.SetTrailer(metadata.Pairs(requestIdHeader, requestId))
+
+		ctx := context.WithValue(ss.Context(), ContextMethodNameKey, info.FullMethod)
+		return handler(srv, &grpc_middleware.WrappedServerStream{
+			ServerStream:   ss,
+			WrappedContext: context.WithValue(ctx, ContextRequestIdKey, requestId),
+		})
+	}
+}
+
+func getIncomingRequestId(ctx context.Context, requestIdHeader string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get(requestIdHeader); len(values) > 0 && values[0] != "" && len(values[0]) <= maxRequestIdLength {
+		return values[0]
+	}
 
-	return handler(srv, &grpc_middleware.WrappedServerStream{
-		ServerStream:   ss,
-		WrappedContext: context.WithValue(ss.Context(), ContextMethodNameKey, info.FullMethod),
-	})
+	return uuid.New().String()
 }
@@ -1,42 +1,213 @@
 package grpc
 
 import (
+	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"strings"
 )
 
-func getDialOptionSecurity(isTLS bool) grpc.DialOption {
+// Configures connections created with CreateConnectionOrDie
+type ClientOption func(o *clientOptions)
+
+type clientOptions struct {
+	rootCAFile         *reloadingFiles
+	certFiles          *reloadingFiles
+	serverNameOverride string
+	dialOptions        []grpc.DialOption
+	requestIdHeader    string
+}
+
+// Verifies the server certificate against the CA of caFile instead of the system cert pool. Only applies with TLS.
+// Unlike the client certificate, the CA is read once when the connection is created, recreate it to trust a new CA
+func WithRootCA(caFile string) ClientOption {
+	return func(o *clientOptions) {
+		o.rootCAFile = newCertPoolFile(caFile)
+	}
+}
+
+// Presents the certificate to servers requiring mTLS. The files are reloaded when they change on disk.
+// Only applies with TLS
+func WithClientCertificate(certFile, keyFile string) ClientOption {
+	return func(o *clientOptions) {
+		o.certFiles = newCertificateFiles(certFile, keyFile)
+	}
+}
+
+// Name used to verify the server certificate instead of the host, ex: when dialing an ip. Only applies with TLS
+func WithServerNameOverride(serverName string) ClientOption {
+	return func(o *clientOptions) {
+		o.serverNameOverride = serverName
+	}
+}
+
+// Options passed as is to grpc.Dial
+func WithDialOptions(dialOptions ...grpc.DialOption) ClientOption {
+	return func(o *clientOptions) {
+		o.dialOptions = append(o.dialOptions, dialOptions...)
+	}
+}
+
+// Metadata header used to forward the request id of the server call to outgoing calls, x-request-id by default.
+// Should match the WithRequestIdHeader of the called servers
+func WithRequestIdForwardingHeader(header string) ClientOption {
+	return func(o *clientOptions) {
+		o.requestIdHeader = strings.ToLower(header)
+	}
+}
+
+// Records the counts, codes, latencies and stream messages of the calls in metrics
+func WithClientMetrics(metrics *ClientMetrics) ClientOption {
+	return WithDialOptions(
+		grpc.WithChainUnaryInterceptor(metrics.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(metrics.StreamClientInterceptor()),
+	)
+}
+
+// Converts the errors of every call into *ClientError so callers can use FromGrpcError, or a type assertion,
+// to get a server.Error with its details
+func WithServerErrors() ClientOption {
+	return WithDialOptions(
+		grpc.WithChainUnaryInterceptor(unaryClientErrorInterceptor),
+		grpc.WithChainStreamInterceptor(streamClientErrorInterceptor),
+	)
+}
+
+func unaryClientErrorInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return newClientError(invoker(ctx, method, req, reply, cc, opts...))
+}
+
+func streamClientErrorInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, newClientError(err)
+	}
+
+	return &errorConvertingClientStream{ClientStream: stream}, nil
+}
+
+type errorConvertingClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *errorConvertingClientStream) SendMsg(m interface{}) error {
+	return newClientError(s.ClientStream.SendMsg(m))
+}
+
+func (s *errorConvertingClientStream) RecvMsg(m interface{}) error {
+	return newClientError(s.ClientStream.RecvMsg(m))
+}
+
+func (s *errorConvertingClientStream) CloseSend() error {
+	return newClientError(s.ClientStream.CloseSend())
+}
+
+// Forwards the request id of ctx, see RequestIDFromContext, unless the caller already set one
+func withForwardedRequestId(ctx context.Context, requestIdHeader string) context.Context {
+	requestId := RequestIDFromContext(ctx)
+	if requestId == "" {
+		return ctx
+	}
+
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestIdHeader)) > 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, requestIdHeader, requestId)
+}
+
+func newUnaryClientRequestIdInterceptor(requestIdHeader string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withForwardedRequestId(ctx, requestIdHeader), method, req, reply, cc, opts...)
+	}
+}
+
+func newStreamClientRequestIdInterceptor(requestIdHeader string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withForwardedRequestId(ctx, requestIdHeader), desc, cc, method, opts...)
+	}
+}
+
+func getDialOptionSecurity(isTLS bool, options *clientOptions) (grpc.DialOption, error) {
 	dialOption := grpc.WithInsecure()
 
 	if isTLS {
 		// https://grpc.io/docs/guides/auth/#authenticate-with-google
 		pool, _ := x509.SystemCertPool()
-		creds := credentials.NewClientTLSFromCert(pool, "")
+
+		if options.rootCAFile != nil {
+			customPool, err := options.rootCAFile.get()
+			if err != nil {
+				return nil, err
+			}
+			pool = customPool.(*x509.CertPool)
+		}
+
+		tlsConfig := &tls.Config{
+			RootCAs:    pool,
+			ServerName: options.serverNameOverride,
+		}
+
+		if options.certFiles != nil {
+			// fail fast on invalid files, later reload errors keep the previous certificate
+			if _, err := options.certFiles.get(); err != nil {
+				return nil, err
+			}
+
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, err := options.certFiles.get()
+				if err != nil {
+					return nil, err
+				}
+				return cert.(*tls.Certificate), nil
+			}
+		}
+
+		creds := credentials.NewTLS(tlsConfig)
 		dialOption = grpc.WithTransportCredentials(creds)
 
 	}
 
-	return dialOption
+	return dialOption, nil
+}
+
+func CreateConnectionOrDie(host string, isTLS bool, options ...ClientOption) *grpc.ClientConn {
+	return createConnectionOrDie(host, isTLS, options)
+}
+
+func CreateConnectionWithMaxMsgSizeOrDie(
+	host string, isTLS bool, maxMsgSizeInBytes int, options ...ClientOption) *grpc.ClientConn {
+	return createConnectionOrDie(host, isTLS, append(options,
+		WithDialOptions(grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSizeInBytes)))))
 }
 
-func CreateConnectionOrDie(host string, isTLS bool) *grpc.ClientConn {
-	conn, err := grpc.Dial(host, getDialOptionSecurity(isTLS))
+func createConnectionOrDie(host string, isTLS bool, options []ClientOption) *grpc.ClientConn {
+	clientOptions := &clientOptions{requestIdHeader: DefaultRequestIdHeader}
+	for _, option := range options {
+		option(clientOptions)
+	}
+
+	securityOption, err := getDialOptionSecurity(isTLS, clientOptions)
 
 	if err != nil {
-		log.Panic().Msgf("could not create grpc connection to %v - %v", host, err)
+		log.Panic().Msgf("could not load tls settings to connect to %v - %v", host, err)
 	}
 
-	return conn
-}
+	dialOptions := []grpc.DialOption{
+		securityOption,
+		grpc.WithChainUnaryInterceptor(newUnaryClientRequestIdInterceptor(clientOptions.requestIdHeader)),
+		grpc.WithChainStreamInterceptor(newStreamClientRequestIdInterceptor(clientOptions.requestIdHeader)),
+	}
 
-func CreateConnectionWithMaxMsgSizeOrDie(host string, isTLS bool, maxMsgSizeInBytes int) *grpc.ClientConn {
-	conn, err := grpc.Dial(
-		host,
-		getDialOptionSecurity(isTLS),
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSizeInBytes)),
-	)
+	conn, err := grpc.Dial(host, append(dialOptions, clientOptions.dialOptions...)...)
 
 	if err != nil {
 		log.Panic().Msgf("could not create grpc connection to %v - %v", host, err)
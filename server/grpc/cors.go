@@ -0,0 +1,114 @@
+package grpc
+
+import (
+	"github.com/rs/cors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const exposeHeadersHeader = "Access-Control-Expose-Headers"
+
+var (
+	// Headers sent by the grpc-web clients, always allowed
+	grpcWebAllowedHeaders = []string{"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout", "Authorization"}
+	// grpc-web already exposes grpc-status and grpc-message
+	grpcWebExposedHeaders = []string{"Grpc-Status-Details-Bin"}
+	defaultAllowedMethods = []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	}
+)
+
+// Cross origin settings of the grpc-web port, including the gateway when it is served there
+type CorsConfig struct {
+	// Origins allowed to call the server, with at most one wildcard each, ex: "https://*.kintohub.com".
+	// "*" allows every origin
+	AllowedOrigins []string
+	// Request headers allowed on top of the ones sent by grpc-web clients and the request id header
+	AllowedHeaders []string
+	// GET, POST, PUT, PATCH and DELETE when empty
+	AllowedMethods []string
+	// Response headers readable by browsers on top of grpc-status, grpc-message and grpc-status-details-bin
+	ExposedHeaders []string
+	// Allows cookies and client certificates. Run fails when every origin is allowed as any site could then
+	// make authenticated calls
+	AllowCredentials bool
+	// How long browsers cache preflight responses, browsers use their own default (5s for chromium) when 0
+	MaxAge time.Duration
+}
+
+// Every origin is allowed when nothing is configured, like the previous cors handling
+func defaultCorsConfig() CorsConfig {
+	return CorsConfig{
+		AllowedOrigins: []string{"*"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Like rs/cors, no origins means every origin
+func (c *CorsConfig) allowsEveryOrigin() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+
+	return len(c.AllowedOrigins) == 0
+}
+
+func (c *CorsConfig) getAllowedHeaders() []string {
+	return append(append([]string{}, grpcWebAllowedHeaders...), c.AllowedHeaders...)
+}
+
+func (c *CorsConfig) getExposedHeaders() []string {
+	return append(append([]string{}, grpcWebExposedHeaders...), c.ExposedHeaders...)
+}
+
+// Answers preflight requests and adds the cors headers of allowed origins to the responses of handler
+func newCorsHandler(config CorsConfig, handler http.Handler) http.Handler {
+	allowedMethods := config.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = defaultAllowedMethods
+	}
+
+	return cors.New(cors.Options{
+		AllowedOrigins:   config.AllowedOrigins,
+		AllowedHeaders:   config.getAllowedHeaders(),
+		AllowedMethods:   allowedMethods,
+		ExposedHeaders:   config.getExposedHeaders(),
+		AllowCredentials: config.AllowCredentials,
+		MaxAge:           int(config.MaxAge / time.Second),
+	}).Handler(handler)
+}
+
+// grpc-web overwrites the exposed headers with the headers of the grpc response, this adds the configured ones back
+type exposedHeadersResponseWriter struct {
+	http.ResponseWriter
+	exposedHeaders []string
+	wroteHeader    bool
+}
+
+func (w *exposedHeadersResponseWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		exposed := append([]string{w.Header().Get(exposeHeadersHeader)}, w.exposedHeaders...)
+		w.Header().Set(exposeHeadersHeader, strings.Join(exposed, ", "))
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *exposedHeadersResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// grpc-web requires a flusher
+func (w *exposedHeadersResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,180 @@
+package grpc_test
+
+import (
+	"bytes"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Returns the url of the server and the function shutting it down
+func startCorsServer(t *testing.T) (string, func()) {
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWebListener(lis),
+		kintoGrpc.WithCors(kintoGrpc.CorsConfig{
+			AllowedOrigins:   []string{"https://*.kintohub.com"},
+			AllowedHeaders:   []string{"X-Request-Id"},
+			ExposedHeaders:   []string{"X-Build-Id"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		}),
+	)
+
+	return "http://" + lis.Addr().String(), stop
+}
+
+func TestCors_Preflight(t *testing.T) {
+	url, stop := startCorsServer(t)
+	defer stop()
+
+	tests := []struct {
+		name            string
+		origin          string
+		requestHeaders  string
+		expectedOrigin  string
+		expectedHeaders string
+	}{
+		{
+			name:            "allowed origin",
+			origin:          "https://app.kintohub.com",
+			requestHeaders:  "x-grpc-web, content-type, x-request-id",
+			expectedOrigin:  "https://app.kintohub.com",
+			expectedHeaders: "X-Grpc-Web, Content-Type, X-Request-Id",
+		},
+		{
+			name:           "origin not allowed",
+			origin:         "https://evil.com",
+			requestHeaders: "x-grpc-web, content-type",
+		},
+		{
+			name:           "header not allowed",
+			origin:         "https://app.kintohub.com",
+			requestHeaders: "x-grpc-web, x-secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodOptions, url+"/grpc.health.v1.Health/Check", nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, test.expectedOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, test.expectedHeaders, resp.Header.Get("Access-Control-Allow-Headers"))
+			if test.expectedOrigin != "" {
+				assert.Equal(t, "POST", resp.Header.Get("Access-Control-Allow-Methods"))
+				assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "3600", resp.Header.Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
+
+func TestCors_GrpcWebRequest(t *testing.T) {
+	url, stop := startCorsServer(t)
+	defer stop()
+
+	call := func(origin string) *http.Response {
+		// an empty HealthCheckRequest: uncompressed flag and zero length
+		req, _ := http.NewRequest(http.MethodPost, url+"/grpc.health.v1.Health/Check",
+			bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		req.Header.Set("X-Grpc-Web", "1")
+		req.Header.Set("Origin", origin)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := call("https://app.kintohub.com")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "https://app.kintohub.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	exposed := resp.Header.Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"grpc-status", "grpc-message", "Grpc-Status-Details-Bin", "X-Build-Id"} {
+		assert.Contains(t, exposed, header)
+	}
+
+	resp = call("https://kintohub.com.evil.com")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.NotContains(t, resp.Header.Get("Access-Control-Expose-Headers"), "X-Build-Id")
+}
+
+func TestCors_AllowedHostsOption(t *testing.T) {
+	lis := newLocalListener(t)
+	// the allowed hosts replace the origins of WithCors even when they come first
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWebListener(lis),
+		kintoGrpc.WithCorsAllowedHosts("https://app.kintohub.com, https://admin.kintohub.com"),
+		kintoGrpc.WithCors(kintoGrpc.CorsConfig{AllowedOrigins: []string{"https://evil.com"}, AllowCredentials: true}),
+	)
+	defer stop()
+
+	for origin, expectedOrigin := range map[string]string{
+		"https://admin.kintohub.com": "https://admin.kintohub.com",
+		"https://evil.com":           "",
+	} {
+		req, _ := http.NewRequest(http.MethodOptions, "http://"+lis.Addr().String()+"/grpc.health.v1.Health/Check", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, expectedOrigin, resp.Header.Get("Access-Control-Allow-Origin"), origin)
+		if expectedOrigin != "" {
+			assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+		}
+	}
+}
+
+func TestCors_RequestIdHeader(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWebListener(lis),
+		kintoGrpc.WithRequestIdHeader("X-Correlation-Id"),
+	)
+	defer stop()
+
+	req, _ := http.NewRequest(http.MethodOptions, "http://"+lis.Addr().String()+"/grpc.health.v1.Health/Check", nil)
+	req.Header.Set("Origin", "https://app.kintohub.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "x-grpc-web, x-correlation-id")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "X-Grpc-Web, X-Correlation-Id", resp.Header.Get("Access-Control-Allow-Headers"))
+}
+
+func TestCors_CredentialsFromEveryOrigin(t *testing.T) {
+	for _, origins := range [][]string{{"*"}, nil} {
+		grpcLis, grpcWebLis := newLocalListener(t), newLocalListener(t)
+		defer grpcLis.Close()
+		defer grpcWebLis.Close()
+
+		s := kintoGrpc.NewServer(
+			kintoGrpc.WithGrpcListener(grpcLis),
+			kintoGrpc.WithGrpcWebListener(grpcWebLis),
+			kintoGrpc.WithCors(kintoGrpc.CorsConfig{AllowedOrigins: origins, AllowCredentials: true}),
+		)
+
+		assert.EqualError(t, s.Run(), "cors cannot allow credentials from every origin, list the allowed origins instead")
+	}
+}
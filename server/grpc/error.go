@@ -2,36 +2,179 @@ package grpc
 
 import (
 	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/kintohub/utils-go/server"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	grpcCodes "google.golang.org/grpc/codes"
 	grpcStatus "google.golang.org/grpc/status"
+	"io"
 )
 
 // Used here and inside logger.go
-const seriousErrorMsg = "a serious error occurred. if the issue persists, please contact the site administrator."
+const seriousErrorMsg = server.SeriousErrorMessage
 
 func ConvertToGrpcError(ctx context.Context, error *server.Error) error {
-	if error.StatusCode >= server.StatusCode_InternalServerError {
-		log.Ctx(ctx).
-			Error().
-			Stack().
-			Err(error.Error).
-			Interface("statusCode", error.StatusCode).
-			Msg(error.Message)
+	server.LogError(log.Ctx(ctx), error)
 
+	if error.StatusCode >= server.StatusCode_InternalServerError {
 		// modify the true error message for the client to just be a serious error vs system error
 		error.Message = seriousErrorMsg
-	} else {
-		log.Ctx(ctx).
-			Error().
-			Err(error.Error).
-			Interface("statusCode", error.StatusCode).
-			Msg(error.Message)
 	}
 
-	return grpcStatus.Error(convertStatusCodeToGrpcCode(error.StatusCode), error.Message)
+	status := grpcStatus.New(convertStatusCodeToGrpcCode(error.StatusCode), error.Message)
+
+	if details := convertToGrpcErrorDetails(error); len(details) > 0 {
+		detailedStatus, err := status.WithDetails(details...)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("could not attach error details to the grpc status")
+		} else {
+			status = detailedStatus
+		}
+	}
+
+	return status.Err()
+}
+
+// Converts the typed details of a server.Error into their google.rpc error details counterpart
+func convertToGrpcErrorDetails(error *server.Error) []proto.Message {
+	var details []proto.Message
+
+	if error.RetryInfo != nil {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: ptypes.DurationProto(error.RetryInfo.RetryDelay),
+		})
+	}
+
+	if error.QuotaFailure != nil {
+		quotaFailure := &errdetails.QuotaFailure{}
+		for _, v := range error.QuotaFailure.Violations {
+			quotaFailure.Violations = append(quotaFailure.Violations, &errdetails.QuotaFailure_Violation{
+				Subject:     v.Subject,
+				Description: v.Description,
+			})
+		}
+		details = append(details, quotaFailure)
+	}
+
+	if error.ErrorInfo != nil {
+		// Type was renamed to Reason in later versions of the proto, the field number is the same
+		details = append(details, &errdetails.ErrorInfo{
+			Type:     error.ErrorInfo.Reason,
+			Domain:   error.ErrorInfo.Domain,
+			Metadata: error.ErrorInfo.Metadata,
+		})
+	}
+
+	if error.PreconditionFailure != nil {
+		preconditionFailure := &errdetails.PreconditionFailure{}
+		for _, v := range error.PreconditionFailure.Violations {
+			preconditionFailure.Violations = append(preconditionFailure.Violations,
+				&errdetails.PreconditionFailure_Violation{
+					Type:        v.Type,
+					Subject:     v.Subject,
+					Description: v.Description,
+				})
+		}
+		details = append(details, preconditionFailure)
+	}
+
+	if error.BadRequest != nil {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range error.BadRequest.FieldViolations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, badRequest)
+	}
+
+	if error.LocalizedMessage != nil {
+		details = append(details, &errdetails.LocalizedMessage{
+			Locale:  error.LocalizedMessage.Locale,
+			Message: error.LocalizedMessage.Message,
+		})
+	}
+
+	return details
+}
+
+// Converts the error of a grpc call back into a server.Error, including its details. The original error is kept
+// in server.Error.Error. Returns nil for a nil error
+func FromGrpcError(err error) *server.Error {
+	if err == nil {
+		return nil
+	}
+
+	if clientErr, ok := err.(*ClientError); ok {
+		return clientErr.ServerError
+	}
+
+	status := grpcStatus.Convert(err)
+	serverError := &server.Error{
+		Message:    status.Message(),
+		StatusCode: convertGrpcCodeToStatusCode(status.Code()),
+		Error:      err,
+	}
+
+	for _, detail := range status.Details() {
+		switch d := detail.(type) {
+		case *errdetails.RetryInfo:
+			delay, _ := ptypes.Duration(d.RetryDelay)
+			serverError.WithRetryInfo(delay)
+		case *errdetails.QuotaFailure:
+			for _, v := range d.Violations {
+				serverError.WithQuotaViolation(v.Subject, v.Description)
+			}
+		case *errdetails.ErrorInfo:
+			serverError.WithErrorInfo(d.Type, d.Domain, d.Metadata)
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.Violations {
+				serverError.WithPreconditionViolation(v.Type, v.Subject, v.Description)
+			}
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				serverError.WithFieldViolation(v.Field, v.Description)
+			}
+		case *errdetails.LocalizedMessage:
+			serverError.WithLocalizedMessage(d.Locale, d.Message)
+		}
+	}
+
+	return serverError
+}
+
+// Error returned by calls made on connections created with WithServerErrors. It still carries the
+// grpc status so grpc utilities such as status.Code keep working
+type ClientError struct {
+	ServerError *server.Error
+	status      *grpcStatus.Status
+}
+
+func (e *ClientError) Error() string {
+	return e.status.Err().Error()
+}
+
+func (e *ClientError) GRPCStatus() *grpcStatus.Status {
+	return e.status
+}
+
+func newClientError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	if _, ok := err.(*ClientError); ok {
+		return err
+	}
+
+	return &ClientError{
+		ServerError: FromGrpcError(err),
+		status:      grpcStatus.Convert(err),
+	}
 }
 
 func panicRecoveryHandler(v interface{}) error {
@@ -42,35 +185,18 @@ func panicRecoveryHandler(v interface{}) error {
 	return grpcStatus.Error(grpcCodes.Internal, seriousErrorMsg)
 }
 
-// Referenced from https://github.com/grpc-ecosystem/grpc-gateway/blob/master/runtime/errors.go
 func convertStatusCodeToGrpcCode(code server.StatusCode) grpcCodes.Code {
-	switch code {
-	case server.StatusCode_OK:
-		return grpcCodes.OK
-	case server.StatusCode_RequestTimeout:
-		return grpcCodes.Canceled
-	case server.StatusCode_InternalServerError:
-		return grpcCodes.Internal
-	case server.StatusCode_BadRequest:
-		return grpcCodes.InvalidArgument
-	case server.StatusCode_GatewayTimeout:
-		return grpcCodes.DeadlineExceeded
-	case server.StatusCode_NotFound:
-		return grpcCodes.NotFound
-	case server.StatusCode_Conflict:
-		return grpcCodes.AlreadyExists
-	case server.StatusCode_Forbidden:
-		return grpcCodes.PermissionDenied
-	case server.StatusCode_Unauthorized:
-		return grpcCodes.Unauthenticated
-	case server.StatusCode_TooManyRequests:
-		return grpcCodes.ResourceExhausted
-	case server.StatusCode_NotImplemented:
-		return grpcCodes.Unimplemented
-	case server.StatusCode_ServiceUnavailable:
-		return grpcCodes.Unavailable
-	default:
+	if !code.IsValid() {
 		log.Warn().Msgf("unsupported kinto error code %v to grpc translation.", code)
-		return grpcCodes.Unknown
 	}
+
+	return grpcCodes.Code(code.RPCCode())
+}
+
+func convertGrpcCodeToStatusCode(code grpcCodes.Code) server.StatusCode {
+	if code > grpcCodes.Unauthenticated {
+		log.Warn().Msgf("unsupported grpc code %v to kinto error translation.", code)
+	}
+
+	return server.StatusCodeFromRPCCode(server.RPCCode(code))
 }
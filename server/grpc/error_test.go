@@ -0,0 +1,93 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/kintohub/utils-go/server"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcStatus "google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestConvertToGrpcError_Details(t *testing.T) {
+	err := kintoGrpc.ConvertToGrpcError(context.Background(),
+		server.NewError(server.StatusCode_TooManyRequests, "build minutes exceeded").
+			WithRetryInfo(30*time.Second).
+			WithQuotaViolation("project:kinto", "daily build minutes exceeded").
+			WithErrorInfo("BUILD_MINUTES_EXCEEDED", "billing.kintohub.com", map[string]string{"limit": "100"}).
+			WithLocalizedMessage("en-US", "You have used all your build minutes for today"))
+
+	status := grpcStatus.Convert(err)
+	assert.Equal(t, grpcCodes.ResourceExhausted, status.Code())
+	assert.Equal(t, "build minutes exceeded", status.Message())
+
+	assert.Equal(t, []interface{}{
+		&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(30 * time.Second)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: "project:kinto", Description: "daily build minutes exceeded"},
+		}},
+		&errdetails.ErrorInfo{Type: "BUILD_MINUTES_EXCEEDED", Domain: "billing.kintohub.com",
+			Metadata: map[string]string{"limit": "100"}},
+		&errdetails.LocalizedMessage{Locale: "en-US", Message: "You have used all your build minutes for today"},
+	}, status.Details())
+}
+
+func TestConvertToGrpcError_HidesInternalMessages(t *testing.T) {
+	err := kintoGrpc.ConvertToGrpcError(context.Background(),
+		server.NewError(server.StatusCode_ServiceUnavailable, "mongo connection refused").
+			WithRetryInfo(time.Second))
+
+	status := grpcStatus.Convert(err)
+	assert.Equal(t, grpcCodes.Unavailable, status.Code())
+	assert.NotContains(t, status.Message(), "mongo")
+	assert.Len(t, status.Details(), 1)
+}
+
+func TestFromGrpcError_ClientInterceptor(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			return nil, kintoGrpc.ConvertToGrpcError(ctx,
+				server.NewError(server.StatusCode_Conflict, "terms of service not accepted").
+					WithPreconditionViolation("TOS", "user:1", "terms of service not accepted").
+					WithErrorInfo("TOS_NOT_ACCEPTED", "auth.kintohub.com", nil))
+		}),
+	)
+	defer stop()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false, kintoGrpc.WithServerErrors())
+	defer conn.Close()
+
+	_, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	clientErr, ok := err.(*kintoGrpc.ClientError)
+	assert.True(t, ok)
+	assert.Equal(t, grpcCodes.AlreadyExists, grpcStatus.Code(err))
+
+	serverErr := kintoGrpc.FromGrpcError(err)
+	assert.Same(t, clientErr.ServerError, serverErr)
+	assert.Equal(t, server.StatusCode_Conflict, serverErr.StatusCode)
+	assert.Equal(t, "terms of service not accepted", serverErr.Message)
+	assert.Equal(t, &server.PreconditionFailure{Violations: []server.PreconditionViolation{
+		{Type: "TOS", Subject: "user:1", Description: "terms of service not accepted"},
+	}}, serverErr.PreconditionFailure)
+	assert.Equal(t, &server.ErrorInfo{Reason: "TOS_NOT_ACCEPTED", Domain: "auth.kintohub.com"}, serverErr.ErrorInfo)
+}
+
+func TestFromGrpcError_NotAStatus(t *testing.T) {
+	assert.Nil(t, kintoGrpc.FromGrpcError(nil))
+
+	serverErr := kintoGrpc.FromGrpcError(errors.New("connection reset"))
+	assert.Equal(t, server.StatusCode_InternalServerError, serverErr.StatusCode)
+	assert.Equal(t, "connection reset", serverErr.Message)
+}
@@ -0,0 +1,258 @@
+package grpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/kintohub/utils-go/server"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Operations has google.api.http annotations, ex: GET /v1/{name=operations/**}
+type operationsServer struct {
+	longrunning.UnimplementedOperationsServer
+}
+
+func (s *operationsServer) GetOperation(
+	ctx context.Context, req *longrunning.GetOperationRequest) (*longrunning.Operation, error) {
+	if req.Name == "operations/missing" {
+		return nil, kintoGrpc.ConvertToGrpcError(ctx, server.NewError(server.StatusCode_NotFound, "no such operation"))
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &longrunning.Operation{Name: req.Name, Done: len(md.Get("authorization")) == 1}, nil
+}
+
+func (s *operationsServer) ListOperations(
+	ctx context.Context, req *longrunning.ListOperationsRequest) (*longrunning.ListOperationsResponse, error) {
+	return &longrunning.ListOperationsResponse{
+		Operations:    []*longrunning.Operation{{Name: req.Name + "/" + req.Filter}},
+		NextPageToken: strings.Repeat("x", int(req.PageSize)),
+	}, nil
+}
+
+func (s *operationsServer) CancelOperation(
+	ctx context.Context, req *longrunning.CancelOperationRequest) (*empty.Empty, error) {
+	return &empty.Empty{}, grpc.SetHeader(ctx, metadata.Pairs("canceled", req.Name))
+}
+
+// Returns the function shutting the server down
+func startGatewayServer(t *testing.T, options ...kintoGrpc.Option) func() {
+	return startServer(t, append([]kintoGrpc.Option{
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			longrunning.RegisterOperationsServer(s, &operationsServer{})
+		}),
+	}, options...)...)
+}
+
+func doGatewayRequest(t *testing.T, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+
+	// the listener already accepts connections while the server starts
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, string(respBody)
+}
+
+func TestGateway(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis))
+	defer stop()
+	url := "http://" + lis.Addr().String()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "path variable spanning segments",
+			method:         "GET",
+			path:           "/v1/operations/builds/42",
+			expectedStatus: 200,
+			expectedBody:   `{"name":"operations/builds/42","done":true}`,
+		},
+		{
+			name:           "query parameters",
+			method:         "GET",
+			path:           "/v1/operations?filter=done&pageSize=3",
+			expectedStatus: 200,
+			expectedBody:   `{"operations":[{"name":"operations/done"}],"nextPageToken":"xxx"}`,
+		},
+		{
+			name:           "custom verb with body",
+			method:         "POST",
+			path:           "/v1/operations/42:cancel",
+			body:           `{}`,
+			expectedStatus: 200,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "fallback path",
+			method:         "POST",
+			path:           "/google.longrunning.Operations/GetOperation",
+			body:           `{"name":"operations/7"}`,
+			expectedStatus: 200,
+			expectedBody:   `{"name":"operations/7","done":true}`,
+		},
+		{
+			name:           "fallback path of a service without annotations",
+			method:         "POST",
+			path:           "/grpc.health.v1.Health/Check",
+			body:           `{}`,
+			expectedStatus: 200,
+			expectedBody:   `{"status":"SERVING"}`,
+		},
+		{
+			name:           "unknown query parameter",
+			method:         "GET",
+			path:           "/v1/operations?color=red",
+			expectedStatus: 400,
+		},
+		{
+			name:           "invalid query parameter",
+			method:         "GET",
+			path:           "/v1/operations?pageSize=many",
+			expectedStatus: 400,
+		},
+		{
+			name:           "unknown path",
+			method:         "GET",
+			path:           "/v2/operations",
+			expectedStatus: 404,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := doGatewayRequest(t, test.method, url+test.path, test.body)
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode, body)
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestGateway_Errors(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis))
+	defer stop()
+
+	resp, body := doGatewayRequest(t, "GET", "http://"+lis.Addr().String()+"/v1/operations/missing", "")
+
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+
+	problem := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &problem))
+	assert.Equal(t, "no such operation", problem["detail"])
+}
+
+func TestGateway_ErrorLogs(t *testing.T) {
+	logs := &logBuffer{}
+	globalLogger := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = globalLogger }()
+
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis))
+	defer stop()
+	url := "http://" + lis.Addr().String()
+
+	resp, _ := doGatewayRequest(t, "GET", url+"/v1/operations/missing", "")
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = doGatewayRequest(t, "GET", url+"/v2/operations", "")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// logged by the grpc server only
+	errorLogs := logs.entries(t, "no such operation")
+	assert.Len(t, errorLogs, 1)
+	assert.Equal(t, "error", errorLogs[0]["level"])
+
+	for _, entry := range logs.entries(t, "GET /v2/operations does not exist") {
+		assert.Equal(t, "debug", entry["level"])
+	}
+}
+
+func TestGateway_PathPrefixOnGrpcWebPort(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWebListener(lis), kintoGrpc.WithGatewayPathPrefix("/api"))
+	defer stop()
+	url := "http://" + lis.Addr().String()
+
+	resp, body := doGatewayRequest(t, "POST", url+"/api/v1/operations/42:cancel", "")
+	assert.Equal(t, 200, resp.StatusCode, body)
+	assert.Equal(t, "operations/42", resp.Header.Get("Grpc-Metadata-Canceled"))
+
+	// everything else is still handled by grpc-web, including paths merely starting like the prefix
+	resp, _ = doGatewayRequest(t, "OPTIONS", url+"/v1/operations/42:cancel", "")
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, _ = doGatewayRequest(t, "GET", url+"/apiary/v1/operations/42", "")
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestGateway_BodyTooLarge(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis),
+		kintoGrpc.WithMaxRecvMsgSize(64))
+	defer stop()
+	url := "http://" + lis.Addr().String() + "/google.longrunning.Operations/GetOperation"
+
+	resp, body := doGatewayRequest(t, "POST", url, `{"name":"operations/42"}`)
+	assert.Equal(t, 200, resp.StatusCode, body)
+
+	resp, body = doGatewayRequest(t, "POST", url, `{"name":"operations/`+strings.Repeat("4", 64)+`"}`)
+	assert.Equal(t, 413, resp.StatusCode, body)
+	assert.Equal(t, server.ProblemContentType, resp.Header.Get("Content-Type"))
+}
+
+func TestGateway_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile, _, ca, caKey := writeCertificate(t, dir, "ca", true, nil, nil)
+	serverCert, serverKey, _, _ := writeCertificate(t, dir, "server", false, ca, caKey)
+
+	// the gateway calls the services in process, without the tls handshake
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis),
+		kintoGrpc.WithTLS(serverCert, serverKey))
+	defer stop()
+
+	resp, body := doGatewayRequest(t, "GET", "http://"+lis.Addr().String()+"/v1/operations/42", "")
+	assert.Equal(t, 200, resp.StatusCode, body)
+
+	// with mTLS the gateway would let calls without client certificate through
+	s := kintoGrpc.NewServer(
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithGatewayListener(newLocalListener(t)),
+		kintoGrpc.WithTLS(serverCert, serverKey),
+		kintoGrpc.WithClientCA(caFile),
+	)
+	assert.EqualError(t, s.Run(), "the gateway cannot be enabled with a client CA, it has no client certificate")
+}
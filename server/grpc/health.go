@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"sort"
+	"time"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// Reports the health of a dependency such as a database or a task broker. Returning an error
+// marks the dependency, and therefore the whole server, as NOT_SERVING.
+// ex: WithHealthChecker("tasks", task.CheckHealth(taskClient))
+type HealthChecker func(ctx context.Context) error
+
+// Runs the registered health checkers periodically and publishes their results
+// through the standard grpc.health.v1.Health service
+type healthService struct {
+	server   *health.Server
+	checkers map[string]HealthChecker
+	interval time.Duration
+}
+
+func newHealthService() *healthService {
+	return &healthService{
+		server:   health.NewServer(),
+		checkers: map[string]HealthChecker{},
+		interval: defaultHealthCheckInterval,
+	}
+}
+
+func (h *healthService) register(server *grpc.Server) {
+	healthpb.RegisterHealthServer(server, h.server)
+}
+
+// Checks every dependency and sets the status of each of them under its own name. The overall status, published under
+// the empty service name and the name of every registered grpc service, is SERVING only when all checks pass
+func (h *healthService) check(ctx context.Context, serviceNames []string) {
+	overallStatus := healthpb.HealthCheckResponse_SERVING
+
+	for _, name := range h.sortedCheckerNames() {
+		checkCtx, cancel := context.WithTimeout(ctx, h.interval)
+		err := h.checkers[name](checkCtx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			log.Warn().Err(err).Msgf("health check %s failed", name)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			overallStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+
+		h.server.SetServingStatus(name, status)
+	}
+
+	h.server.SetServingStatus("", overallStatus)
+	for _, serviceName := range serviceNames {
+		h.server.SetServingStatus(serviceName, overallStatus)
+	}
+}
+
+// Blocking call checking the dependencies every interval until ctx is done
+func (h *healthService) run(ctx context.Context, serviceNames []string) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx, serviceNames)
+		}
+	}
+}
+
+// Flips every status to NOT_SERVING and ignores future updates so load balancers stop sending traffic
+func (h *healthService) shutdown() {
+	h.server.Shutdown()
+}
+
+func (h *healthService) sortedCheckerNames() []string {
+	names := make([]string, 0, len(h.checkers))
+	for name := range h.checkers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -2,39 +2,215 @@ package grpc
 
 import (
 	"context"
-	"github.com/google/uuid"
+	protoV1 "github.com/golang/protobuf/proto"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
 )
 
-func unaryLoggingInterceptor(
+const defaultMaxLoggedPayloadSize = 4 * 1024
+
+// Settings of the access logs written when grpc calls complete
+type AccessLogConfig struct {
+	// Level of the access logs per grpc code, merged over the defaults: debug for OK, info for the codes caused by
+	// clients, ex: NotFound, and error for the ones caused by the server, ex: Internal
+	Levels map[codes.Code]zerolog.Level
+	// Calls lasting longer are logged at least as warnings with slow=true, no slow warnings when 0
+	SlowThreshold time.Duration
+	// Fraction, from 0 to 1, of the unary calls logged with their request and response as json. Payloads are
+	// redacted and truncated like the ones of WithPayloadLogging, and only rendered for the calls accepted by the
+	// interceptors of WithUnaryInterceptors, ex: authenticated
+	PayloadSampleRate float64
+	// Logger the request loggers and access logs derive from, the global logger when nil
+	Logger *zerolog.Logger
+}
+
+var defaultAccessLogLevels = map[codes.Code]zerolog.Level{
+	codes.OK:                 zerolog.DebugLevel,
+	codes.Canceled:           zerolog.InfoLevel,
+	codes.InvalidArgument:    zerolog.InfoLevel,
+	codes.NotFound:           zerolog.InfoLevel,
+	codes.AlreadyExists:      zerolog.InfoLevel,
+	codes.PermissionDenied:   zerolog.InfoLevel,
+	codes.ResourceExhausted:  zerolog.InfoLevel,
+	codes.FailedPrecondition: zerolog.InfoLevel,
+	codes.Aborted:            zerolog.InfoLevel,
+	codes.OutOfRange:         zerolog.InfoLevel,
+	codes.Unauthenticated:    zerolog.InfoLevel,
+	codes.Unknown:            zerolog.ErrorLevel,
+	codes.DeadlineExceeded:   zerolog.ErrorLevel,
+	codes.Unimplemented:      zerolog.ErrorLevel,
+	codes.Internal:           zerolog.ErrorLevel,
+	codes.Unavailable:        zerolog.ErrorLevel,
+	codes.DataLoss:           zerolog.ErrorLevel,
+}
+
+type accessLogger struct {
+	config AccessLogConfig
+	levels map[codes.Code]zerolog.Level
+}
+
+func newAccessLogger(config AccessLogConfig) *accessLogger {
+	levels := map[codes.Code]zerolog.Level{}
+	for code, level := range defaultAccessLogLevels {
+		levels[code] = level
+	}
+
+	for code, level := range config.Levels {
+		levels[code] = level
+	}
+
+	if config.Logger == nil {
+		config.Logger = &log.Logger
+	}
+
+	return &accessLogger{
+		config: config,
+		levels: levels,
+	}
+}
+
+func (l *accessLogger) unaryInterceptor(
 	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
 	resp interface{}, err error) {
-	logger := createRequestLogger(info.FullMethod)
+	logger := createRequestLogger(ctx, l.config.Logger, info.FullMethod)
+	start := time.Now()
 
 	logger.Debug().Msg("...starting to process new grpc request")
 
-	return handler(logger.WithContext(ctx), req)
+	var payloads *sampledPayloads
+	if l.config.PayloadSampleRate > 0 && rand.Float64() < l.config.PayloadSampleRate {
+		payloads = &sampledPayloads{}
+		ctx = context.WithValue(ctx, sampledPayloadsKey{}, payloads)
+	}
+
+	resp, err = handler(logger.WithContext(ctx), req)
+
+	event := l.newEvent(ctx, &logger, err, time.Since(start)).
+		Int("requestSize", getMessageSize(req)).
+		Int("responseSize", getMessageSize(resp))
+
+	if payloads != nil && payloads.isRendered {
+		event = event.
+			Str("request", payloads.request).
+			Str("response", payloads.response)
+	}
+
+	event.Msg("finished processing grpc request")
+
+	return resp, err
 }
 
-func streamLoggingInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+func (l *accessLogger) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler) error {
-	logger := createRequestLogger(info.FullMethod)
+	logger := createRequestLogger(ss.Context(), l.config.Logger, info.FullMethod)
+	start := time.Now()
 
 	logger.Debug().Msg("...starting to process new grpc stream")
 
-	return handler(srv, &grpc_middleware.WrappedServerStream{
-		ServerStream:   ss,
-		WrappedContext: logger.WithContext(ss.Context()),
-	})
+	stream := &sizeCountingServerStream{
+		WrappedServerStream: &grpc_middleware.WrappedServerStream{
+			ServerStream:   ss,
+			WrappedContext: logger.WithContext(ss.Context()),
+		},
+	}
+
+	err := handler(srv, stream)
+
+	l.newEvent(ss.Context(), &logger, err, time.Since(start)).
+		Int("messagesReceived", stream.messagesReceived).
+		Int("messagesSent", stream.messagesSent).
+		Int("requestSize", stream.requestSize).
+		Int("responseSize", stream.responseSize).
+		Msg("finished processing grpc stream")
+
+	return err
+}
+
+// Event with the fields shared by unary and stream calls, at the level of the code of err
+func (l *accessLogger) newEvent(
+	ctx context.Context, logger *zerolog.Logger, err error, duration time.Duration) *zerolog.Event {
+	code := status.Code(err)
+
+	level, ok := l.levels[code]
+	if !ok {
+		level = zerolog.ErrorLevel
+	}
+
+	isSlow := l.config.SlowThreshold > 0 && duration > l.config.SlowThreshold
+	if isSlow && level < zerolog.WarnLevel {
+		level = zerolog.WarnLevel
+	}
+
+	event := logger.WithLevel(level).
+		Str("code", code.String()).
+		Dur("duration", duration).
+		Bool("slow", isSlow)
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		event = event.Str("peer", p.Addr.String())
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("user-agent")) > 0 {
+		event = event.Str("userAgent", md.Get("user-agent")[0])
+	}
+
+	if code != codes.OK {
+		event = event.Str("statusMessage", status.Convert(err).Message())
+	}
+
+	return event
+}
+
+// Counts the messages of a stream and their sizes for the access logs
+type sizeCountingServerStream struct {
+	*grpc_middleware.WrappedServerStream
+	messagesReceived int
+	messagesSent     int
+	requestSize      int
+	responseSize     int
+}
+
+func (s *sizeCountingServerStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil {
+		s.messagesReceived++
+		s.requestSize += getMessageSize(m)
+	}
+
+	return err
+}
+
+func (s *sizeCountingServerStream) SendMsg(m interface{}) error {
+	err := s.WrappedServerStream.SendMsg(m)
+	if err == nil {
+		s.messagesSent++
+		s.responseSize += getMessageSize(m)
+	}
+
+	return err
+}
+
+// Size of the protobuf encoding of m, 0 when m is not a protobuf message
+func getMessageSize(m interface{}) int {
+	if message, ok := m.(protoV1.Message); ok {
+		return protoV1.Size(message)
+	}
+
+	return 0
 }
 
-func createRequestLogger(requestName string) zerolog.Logger {
-	return log.With().
+func createRequestLogger(ctx context.Context, base *zerolog.Logger, requestName string) zerolog.Logger {
+	return base.With().
 		Caller(). // For all calls that do not have errors - simple stack trace
-		Str("requestId", uuid.New().String()).
+		Str("requestId", RequestIDFromContext(ctx)).
 		Str("requestName", requestName).
 		Logger()
 }
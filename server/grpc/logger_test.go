@@ -0,0 +1,182 @@
+package grpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the logs written while the test runs
+type logBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// Decoded logs written so far with one of messages
+func (b *logBuffer) entries(t *testing.T, messages ...string) []map[string]interface{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var logs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buffer.String()), "\n") {
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		for _, message := range messages {
+			if entry["message"] == message {
+				logs = append(logs, entry)
+			}
+		}
+	}
+
+	return logs
+}
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          kintoGrpc.AccessLogConfig
+		operation       string
+		expectedCode    string
+		expectedLevel   string
+		expectedSlow    bool
+		expectedRequest string
+	}{
+		{
+			name:          "ok",
+			expectedCode:  "OK",
+			expectedLevel: "debug",
+		},
+		{
+			name:          "client error",
+			operation:     "fail",
+			expectedCode:  "NotFound",
+			expectedLevel: "info",
+		},
+		{
+			name:          "configured level",
+			config:        kintoGrpc.AccessLogConfig{Levels: map[codes.Code]zerolog.Level{codes.NotFound: zerolog.WarnLevel}},
+			operation:     "fail",
+			expectedCode:  "NotFound",
+			expectedLevel: "warn",
+		},
+		{
+			name:          "slow call",
+			config:        kintoGrpc.AccessLogConfig{SlowThreshold: time.Nanosecond},
+			expectedCode:  "OK",
+			expectedLevel: "warn",
+			expectedSlow:  true,
+		},
+		{
+			name:            "sampled payloads",
+			config:          kintoGrpc.AccessLogConfig{PayloadSampleRate: 1},
+			operation:       "builds/42",
+			expectedCode:    "OK",
+			expectedLevel:   "debug",
+			expectedRequest: `{"name":"builds/42"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := &logBuffer{}
+			logger := zerolog.New(logs)
+			test.config.Logger = &logger
+			client, stop := startRequestIdServer(t, nil, kintoGrpc.WithAccessLog(test.config))
+			defer stop()
+
+			_, _ = client.GetOperation(context.Background(), &longrunning.GetOperationRequest{Name: test.operation})
+
+			accessLogs := logs.entries(t, "finished processing grpc request")
+			assert.Len(t, accessLogs, 1)
+			accessLog := accessLogs[0]
+
+			assert.Equal(t, "/google.longrunning.Operations/GetOperation", accessLog["requestName"])
+			assert.Equal(t, test.expectedCode, accessLog["code"])
+			assert.Equal(t, test.expectedLevel, accessLog["level"])
+			assert.Equal(t, test.expectedSlow, accessLog["slow"])
+			assert.Contains(t, accessLog["peer"], "127.0.0.1:")
+			assert.Contains(t, accessLog["userAgent"], "grpc-go")
+			assert.Contains(t, accessLog, "duration")
+			assert.Contains(t, accessLog, "requestSize")
+			assert.Contains(t, accessLog, "responseSize")
+
+			if test.expectedRequest != "" {
+				assert.Equal(t, test.expectedRequest, accessLog["request"])
+				assert.Equal(t, float64(len(test.operation)+2), accessLog["requestSize"])
+				assert.Contains(t, accessLog, "response")
+			} else {
+				assert.NotContains(t, accessLog, "request")
+			}
+		})
+	}
+}
+
+func TestAccessLog_SampledPayloads(t *testing.T) {
+	tests := []struct {
+		name            string
+		operation       string
+		expectedRequest string
+	}{
+		{
+			name:            "redacted like the payload logs",
+			operation:       "builds/42",
+			expectedRequest: `{"name":"[REDACTED]"}`,
+		},
+		{
+			name:      "rejected by the user interceptors",
+			operation: "rejected",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := &logBuffer{}
+			logger := zerolog.New(logs)
+			client, stop := startRequestIdServer(t, nil,
+				kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{PayloadSampleRate: 1, Logger: &logger}),
+				kintoGrpc.WithPayloadLogging(kintoGrpc.PayloadLogConfig{
+					Redaction: kintoGrpc.PayloadRedaction{Fields: []string{"name"}},
+				}),
+				// an authentication interceptor rejecting some calls
+				kintoGrpc.WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+					handler grpc.UnaryHandler) (interface{}, error) {
+					if req.(*longrunning.GetOperationRequest).Name == "rejected" {
+						return nil, status.Error(codes.Unauthenticated, "missing token")
+					}
+					return handler(ctx, req)
+				}),
+			)
+			defer stop()
+
+			_, _ = client.GetOperation(context.Background(), &longrunning.GetOperationRequest{Name: test.operation})
+
+			accessLogs := logs.entries(t, "finished processing grpc request")
+			assert.Len(t, accessLogs, 1)
+
+			if test.expectedRequest != "" {
+				assert.Equal(t, test.expectedRequest, accessLogs[0]["request"])
+				assert.Contains(t, accessLogs[0], "response")
+			} else {
+				assert.NotContains(t, accessLogs[0], "request")
+				assert.NotContains(t, accessLogs[0], "response")
+			}
+		})
+	}
+}
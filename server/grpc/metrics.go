@@ -0,0 +1,272 @@
+package grpc
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceLabel = "grpc_service"
+	methodLabel  = "grpc_method"
+	typeLabel    = "grpc_type"
+	codeLabel    = "grpc_code"
+)
+
+// Collectors shared by the server and client metrics, labeled by service, method and call type
+type callMetrics struct {
+	started     *prometheus.CounterVec
+	handled     *prometheus.CounterVec
+	inFlight    *prometheus.GaugeVec
+	duration    *prometheus.HistogramVec
+	msgReceived *prometheus.CounterVec
+	msgSent     *prometheus.CounterVec
+}
+
+// side is "server" or "client"
+func newCallMetrics(namespace, side string, registerer prometheus.Registerer) (*callMetrics, error) {
+	labels := []string{serviceLabel, methodLabel, typeLabel}
+	subsystem := "grpc_" + side
+
+	newCounter := func(name, help string, labels []string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	m := &callMetrics{
+		started: newCounter("started_total", "Total number of grpc calls started on the "+side+".", labels),
+		handled: newCounter("handled_total", "Total number of grpc calls completed on the "+side+", by code.",
+			append(labels, codeLabel)),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "in_flight",
+			Help:      "Number of grpc calls currently in progress on the " + side + ".",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "handling_seconds",
+			Help:      "Time until grpc calls completed on the " + side + ".",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, labels),
+		msgReceived: newCounter("msg_received_total",
+			"Total number of stream messages received by the "+side+".", labels),
+		msgSent: newCounter("msg_sent_total", "Total number of stream messages sent by the "+side+".", labels),
+	}
+
+	collectors := []prometheus.Collector{m.started, m.handled, m.inFlight, m.duration, m.msgReceived, m.msgSent}
+
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Label values of a single call
+type callLabels []string
+
+func newCallLabels(fullMethod string, isClientStream, isServerStream bool) callLabels {
+	service, method := "unknown", "unknown"
+	// full methods look like /package.Service/Method
+	if parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2); len(parts) == 2 {
+		service, method = parts[0], parts[1]
+	}
+
+	callType := "unary"
+	switch {
+	case isClientStream && isServerStream:
+		callType = "bidi_stream"
+	case isClientStream:
+		callType = "client_stream"
+	case isServerStream:
+		callType = "server_stream"
+	}
+
+	return callLabels{service, method, callType}
+}
+
+func (m *callMetrics) start(labels callLabels) time.Time {
+	m.started.WithLabelValues(labels...).Inc()
+	m.inFlight.WithLabelValues(labels...).Inc()
+	return time.Now()
+}
+
+func (m *callMetrics) finish(labels callLabels, start time.Time, err error) {
+	m.inFlight.WithLabelValues(labels...).Dec()
+	m.handled.WithLabelValues(append(labels, status.Code(err).String())...).Inc()
+	m.duration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+// Prometheus collectors of the calls handled by a server, see WithServerMetrics
+type ServerMetrics struct {
+	*callMetrics
+}
+
+// Creates the grpc server collectors and registers them with registerer. namespace is prepended to every metric
+// name and can be left empty. Use prometheus.DefaultRegisterer to expose the metrics alongside the go runtime metrics
+func NewServerMetrics(namespace string, registerer prometheus.Registerer) (*ServerMetrics, error) {
+	m, err := newCallMetrics(namespace, "server", registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerMetrics{callMetrics: m}, nil
+}
+
+func (m *ServerMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		resp interface{}, err error) {
+		labels := newCallLabels(info.FullMethod, false, false)
+		start := m.start(labels)
+
+		resp, err = handler(ctx, req)
+
+		m.finish(labels, start, err)
+		return resp, err
+	}
+}
+
+func (m *ServerMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		labels := newCallLabels(info.FullMethod, info.IsClientStream, info.IsServerStream)
+		start := m.start(labels)
+
+		err := handler(srv, &messageCountingServerStream{ServerStream: ss, metrics: m.callMetrics, labels: labels})
+
+		m.finish(labels, start, err)
+		return err
+	}
+}
+
+type messageCountingServerStream struct {
+	grpc.ServerStream
+	metrics *callMetrics
+	labels  callLabels
+}
+
+func (s *messageCountingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.metrics.msgReceived.WithLabelValues(s.labels...).Inc()
+	}
+
+	return err
+}
+
+func (s *messageCountingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.metrics.msgSent.WithLabelValues(s.labels...).Inc()
+	}
+
+	return err
+}
+
+// Prometheus collectors of the calls made by clients, see WithClientMetrics
+type ClientMetrics struct {
+	*callMetrics
+}
+
+// Creates the grpc client collectors and registers them with registerer, see NewServerMetrics.
+// Share one ClientMetrics between all the connections of a process
+func NewClientMetrics(namespace string, registerer prometheus.Registerer) (*ClientMetrics, error) {
+	m, err := newCallMetrics(namespace, "client", registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientMetrics{callMetrics: m}, nil
+}
+
+func (m *ClientMetrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		labels := newCallLabels(method, false, false)
+		start := m.start(labels)
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		m.finish(labels, start, err)
+		return err
+	}
+}
+
+// Streams are complete once RecvMsg returns an error, io.EOF when they succeed, or the response of client streams.
+// Streams that are not read until then stay in flight
+func (m *ClientMetrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		labels := newCallLabels(method, desc.ClientStreams, desc.ServerStreams)
+		start := m.start(labels)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			m.finish(labels, start, err)
+			return nil, err
+		}
+
+		return &messageCountingClientStream{
+			ClientStream:  stream,
+			metrics:       m.callMetrics,
+			labels:        labels,
+			start:         start,
+			serverStreams: desc.ServerStreams,
+		}, nil
+	}
+}
+
+type messageCountingClientStream struct {
+	grpc.ClientStream
+	metrics *callMetrics
+	labels  callLabels
+	start   time.Time
+	// without server streams the single response completes the call, RecvMsg returns nil rather than io.EOF
+	serverStreams bool
+	finishOnce    sync.Once
+}
+
+func (s *messageCountingClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	switch err {
+	case nil:
+		s.metrics.msgReceived.WithLabelValues(s.labels...).Inc()
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	case io.EOF:
+		s.finish(nil)
+	default:
+		s.finish(err)
+	}
+
+	return err
+}
+
+func (s *messageCountingClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.metrics.msgSent.WithLabelValues(s.labels...).Inc()
+	}
+
+	return err
+}
+
+func (s *messageCountingClientStream) finish(err error) {
+	s.finishOnce.Do(func() {
+		s.metrics.finish(s.labels, s.start, err)
+	})
+}
@@ -0,0 +1,152 @@
+package grpc_test
+
+import (
+	"context"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Client stream answering with the number of messages received
+var countServiceDesc = &grpc.ServiceDesc{
+	ServiceName: "kinto.test.Count",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Count",
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			count := 0
+			for {
+				err := stream.RecvMsg(&wrapperspb.StringValue{})
+				if err == io.EOF {
+					return stream.SendMsg(&wrapperspb.Int32Value{Value: int32(count)})
+				}
+				if err != nil {
+					return err
+				}
+				count++
+			}
+		},
+	}},
+}
+
+func TestMetrics(t *testing.T) {
+	serverRegistry := prometheus.NewRegistry()
+	serverMetrics, err := kintoGrpc.NewServerMetrics("kinto", serverRegistry)
+	assert.NoError(t, err)
+
+	clientRegistry := prometheus.NewRegistry()
+	clientMetrics, err := kintoGrpc.NewClientMetrics("kinto", clientRegistry)
+	assert.NoError(t, err)
+
+	lis := newLocalListener(t)
+	metricsLis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithServerMetrics(serverMetrics),
+		kintoGrpc.WithMetricsListener(metricsLis, serverRegistry),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			longrunning.RegisterOperationsServer(s, &requestIdServer{})
+			s.RegisterService(countServiceDesc, struct{}{})
+		}),
+	)
+	defer stop()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false, kintoGrpc.WithClientMetrics(clientMetrics))
+	defer conn.Close()
+
+	client := longrunning.NewOperationsClient(conn)
+	_, err = client.GetOperation(context.Background(), &longrunning.GetOperationRequest{})
+	assert.NoError(t, err)
+	_, err = client.GetOperation(context.Background(), &longrunning.GetOperationRequest{Name: "fail"})
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	assert.Error(t, err)
+
+	countStream, err := conn.NewStream(context.Background(), &countServiceDesc.Streams[0], "/kinto.test.Count/Count")
+	assert.NoError(t, err)
+	assert.NoError(t, countStream.SendMsg(&wrapperspb.StringValue{Value: "a"}))
+	assert.NoError(t, countStream.SendMsg(&wrapperspb.StringValue{Value: "b"}))
+	assert.NoError(t, countStream.CloseSend())
+	count := &wrapperspb.Int32Value{}
+	assert.NoError(t, countStream.RecvMsg(count))
+	assert.Equal(t, int32(2), count.Value)
+
+	for _, side := range []struct {
+		name     string
+		registry *prometheus.Registry
+	}{{"server", serverRegistry}, {"client", clientRegistry}} {
+		t.Run(side.name, func(t *testing.T) {
+			expected := `
+# HELP kinto_grpc_SIDE_handled_total Total number of grpc calls completed on the SIDE, by code.
+# TYPE kinto_grpc_SIDE_handled_total counter
+kinto_grpc_SIDE_handled_total{grpc_code="Canceled",grpc_method="Watch",grpc_service="grpc.health.v1.Health",grpc_type="server_stream"} 1
+kinto_grpc_SIDE_handled_total{grpc_code="OK",grpc_method="Count",grpc_service="kinto.test.Count",grpc_type="client_stream"} 1
+kinto_grpc_SIDE_handled_total{grpc_code="NotFound",grpc_method="GetOperation",grpc_service="google.longrunning.Operations",grpc_type="unary"} 1
+kinto_grpc_SIDE_handled_total{grpc_code="OK",grpc_method="GetOperation",grpc_service="google.longrunning.Operations",grpc_type="unary"} 1
+# HELP kinto_grpc_SIDE_in_flight Number of grpc calls currently in progress on the SIDE.
+# TYPE kinto_grpc_SIDE_in_flight gauge
+kinto_grpc_SIDE_in_flight{grpc_method="GetOperation",grpc_service="google.longrunning.Operations",grpc_type="unary"} 0
+kinto_grpc_SIDE_in_flight{grpc_method="Watch",grpc_service="grpc.health.v1.Health",grpc_type="server_stream"} 0
+kinto_grpc_SIDE_in_flight{grpc_method="Count",grpc_service="kinto.test.Count",grpc_type="client_stream"} 0
+`
+			expected = strings.ReplaceAll(expected, "SIDE", side.name)
+			metricNames := []string{
+				"kinto_grpc_" + side.name + "_handled_total", "kinto_grpc_" + side.name + "_in_flight",
+			}
+
+			// the server sees the cancellation of the stream asynchronously
+			assert.Eventually(t, func() bool {
+				return testutil.GatherAndCompare(side.registry, strings.NewReader(expected), metricNames...) == nil
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.NoError(t, testutil.GatherAndCompare(side.registry, strings.NewReader(expected), metricNames...))
+
+			count, err := testutil.GatherAndCount(side.registry, "kinto_grpc_"+side.name+"_handling_seconds")
+			assert.NoError(t, err)
+			assert.Equal(t, 3, count)
+		})
+	}
+
+	expectedMessages := `
+# HELP kinto_grpc_server_msg_sent_total Total number of stream messages sent by the server.
+# TYPE kinto_grpc_server_msg_sent_total counter
+kinto_grpc_server_msg_sent_total{grpc_method="Count",grpc_service="kinto.test.Count",grpc_type="client_stream"} 1
+kinto_grpc_server_msg_sent_total{grpc_method="Watch",grpc_service="grpc.health.v1.Health",grpc_type="server_stream"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(serverRegistry, strings.NewReader(expectedMessages),
+		"kinto_grpc_server_msg_sent_total"))
+
+	expectedClientMessages := `
+# HELP kinto_grpc_client_msg_sent_total Total number of stream messages sent by the client.
+# TYPE kinto_grpc_client_msg_sent_total counter
+kinto_grpc_client_msg_sent_total{grpc_method="Count",grpc_service="kinto.test.Count",grpc_type="client_stream"} 2
+kinto_grpc_client_msg_sent_total{grpc_method="Watch",grpc_service="grpc.health.v1.Health",grpc_type="server_stream"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(clientRegistry, strings.NewReader(expectedClientMessages),
+		"kinto_grpc_client_msg_sent_total"))
+
+	resp, err := http.Get("http://" + metricsLis.Addr().String() + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "kinto_grpc_server_started_total")
+}
@@ -0,0 +1,272 @@
+package grpc
+
+import (
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"net"
+	"strings"
+	"time"
+)
+
+// Configures a Server created with NewServer
+type Option func(s *Server)
+
+// Port used to listen for native grpc connections. Ignored when WithGrpcListener is used, Run fails without either
+func WithGrpcPort(port string) Option {
+	return func(s *Server) {
+		s.grpcPort = port
+	}
+}
+
+// Serve native grpc connections on an existing listener instead of a port
+func WithGrpcListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.grpcListener = listener
+	}
+}
+
+// Port used to listen for grpc-web connections. Ignored when WithGrpcWebListener is used, Run fails without either
+// unless grpc-web is disabled
+func WithGrpcWebPort(port string) Option {
+	return func(s *Server) {
+		s.grpcWebPort = port
+	}
+}
+
+// Serve grpc-web connections on an existing listener instead of a port
+func WithGrpcWebListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.grpcWebListener = listener
+	}
+}
+
+// Enables or disables the grpc-web server. Enabled by default
+func WithGrpcWeb(enabled bool) Option {
+	return func(s *Server) {
+		s.grpcWebEnabled = enabled
+	}
+}
+
+// Extra options passed to the grpc-web wrapper
+func WithGrpcWebOptions(options ...grpcweb.Option) Option {
+	return func(s *Server) {
+		s.grpcWebOptions = append(s.grpcWebOptions, options...)
+	}
+}
+
+// Serves the http/json gateway, which transcodes rest calls to the registered services, on its own port
+func WithGatewayPort(port string) Option {
+	return func(s *Server) {
+		s.gatewayPort = port
+	}
+}
+
+// Serves the http/json gateway on an existing listener, see WithGatewayPort
+func WithGatewayListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.gatewayListener = listener
+	}
+}
+
+// Serves the http/json gateway under the path prefix, ex: "/api". Without a gateway port or listener the
+// gateway is served on the grpc-web port, next to grpc-web
+func WithGatewayPathPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.gatewayPathPrefix = prefix
+	}
+}
+
+// Comma separated origins allowed to call the grpc-web port, see WithCors for the other settings.
+// Replaces the AllowedOrigins of WithCors whatever their order
+func WithCorsAllowedHosts(corsAllowedHosts string) Option {
+	return func(s *Server) {
+		origins := []string{}
+		for _, origin := range strings.Split(corsAllowedHosts, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		s.corsAllowedHosts = origins
+	}
+}
+
+// Enables the grpc-web websocket transport, which browsers need for client and bidirectional streams
+func WithGrpcWebsockets(config WebsocketConfig) Option {
+	return func(s *Server) {
+		s.websocket = &config
+	}
+}
+
+// Cross origin settings of the grpc-web port. Every origin is allowed by default
+func WithCors(config CorsConfig) Option {
+	return func(s *Server) {
+		s.cors = config
+	}
+}
+
+// Metadata header of the incoming request ids, x-request-id by default. Calls without it get a new uuid
+func WithRequestIdHeader(header string) Option {
+	return func(s *Server) {
+		s.requestIdHeader = strings.ToLower(header)
+	}
+}
+
+// Levels, slow call warnings and payload sampling of the access logs written when calls complete
+func WithAccessLog(config AccessLogConfig) Option {
+	return func(s *Server) {
+		s.accessLog = config
+	}
+}
+
+// Logs the requests, responses and stream messages as json with their sensitive fields redacted
+func WithPayloadLogging(config PayloadLogConfig) Option {
+	return func(s *Server) {
+		s.payloadLog = &config
+	}
+}
+
+// Records the counts, codes, latencies and stream messages of the calls in metrics
+func WithServerMetrics(metrics *ServerMetrics) Option {
+	return func(s *Server) {
+		s.metrics = metrics
+	}
+}
+
+// Serves the metrics of gatherer on :port/metrics, ex: prometheus.DefaultGatherer.
+// Ignored when WithMetricsListener is used
+func WithMetricsPort(port string, gatherer prometheus.Gatherer) Option {
+	return func(s *Server) {
+		s.metricsPort = port
+		s.metricsGatherer = gatherer
+	}
+}
+
+// Serve the metrics of gatherer on an existing listener instead of a port
+func WithMetricsListener(listener net.Listener, gatherer prometheus.Gatherer) Option {
+	return func(s *Server) {
+		s.metricsListener = listener
+		s.metricsGatherer = gatherer
+	}
+}
+
+// Interceptors called after the default ones (enrich call, logging, panic recovery, authentication),
+// so they have access to the method name and request logger and their panics are recovered
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) {
+		s.unaryInterceptors = append(s.unaryInterceptors, interceptors...)
+	}
+}
+
+// Stream counterpart of WithUnaryInterceptors
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(s *Server) {
+		s.streamInterceptors = append(s.streamInterceptors, interceptors...)
+	}
+}
+
+// Options passed as is to grpc.NewServer, ex: grpc.Creds, grpc.KeepaliveParams or grpc.MaxRecvMsgSize.
+// Interceptors must be added with WithUnaryInterceptors and WithStreamInterceptors instead
+func WithServerOptions(options ...grpc.ServerOption) Option {
+	return func(s *Server) {
+		s.serverOptions = append(s.serverOptions, options...)
+	}
+}
+
+// Largest request message accepted by grpc, also the largest request body of the gateway. 4MB by default
+func WithMaxRecvMsgSize(bytes int) Option {
+	return func(s *Server) {
+		s.maxRecvMsgSize = bytes
+		s.serverOptions = append(s.serverOptions, grpc.MaxRecvMsgSize(bytes))
+	}
+}
+
+// Handlers registering the grpc services implementations
+func WithServiceHandlers(handlers ...RegisterServiceHandler) Option {
+	return func(s *Server) {
+		s.handlers = append(s.handlers, handlers...)
+	}
+}
+
+// Maximum time to wait for in-flight requests during a graceful shutdown before closing
+// the remaining connections. Defaults to 30 seconds
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// Registers a named dependency check aggregated into the grpc.health.v1.Health service status
+func WithHealthChecker(name string, checker HealthChecker) Option {
+	return func(s *Server) {
+		s.health.checkers[name] = checker
+	}
+}
+
+// How often the health checkers run, also used as their timeout. Defaults to 10 seconds
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.health.interval = interval
+	}
+}
+
+// Registers the grpc server reflection service so tools like grpcurl can list and call the services.
+// Defaults to the GRPC_REFLECTION_ENABLED env var, disabled when unset
+func WithReflection(enabled bool) Option {
+	return func(s *Server) {
+		s.reflectionEnabled = enabled
+	}
+}
+
+// Registers the channelz service exposing connection and channel level debugging data.
+// Defaults to the GRPC_CHANNELZ_ENABLED env var, disabled when unset
+func WithChannelz(enabled bool) Option {
+	return func(s *Server) {
+		s.channelzEnabled = enabled
+	}
+}
+
+// Serves native grpc connections over TLS. The files are reloaded when they change on disk.
+// grpc-web connections stay in plaintext as TLS is usually terminated by the ingress in front of them
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.certFiles = newCertificateFiles(certFile, keyFile)
+	}
+}
+
+// How often the files of WithTLS and WithClientCA are checked for changes. Defaults to 10 seconds
+func WithTLSReloadInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.tlsReloadInterval = interval
+	}
+}
+
+// Requires grpc clients to present a certificate signed by the CA of caFile (mTLS). Only applies with WithTLS
+func WithClientCA(caFile string) Option {
+	return func(s *Server) {
+		s.clientCAFile = newCertPoolFile(caFile)
+	}
+}
+
+// Rejects calls without a valid bearer jwt, except for the public methods of authenticator.
+// Runs before the interceptors of WithUnaryInterceptors and WithStreamInterceptors
+func WithAuthenticator(authenticator *Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// Rejects calls not matching the policies of authorizer. Runs right after the authenticator
+func WithAuthorizer(authorizer *Authorizer) Option {
+	return func(s *Server) {
+		s.authorizer = authorizer
+	}
+}
+
+// Validates requests implementing validation.Validatable (ozzo) or protoc-gen-validate's Validate() before
+// calling the handler. Enabled by default
+func WithRequestValidation(enabled bool) Option {
+	return func(s *Server) {
+		s.requestValidationEnabled = enabled
+	}
+}
@@ -0,0 +1,273 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	protoV1 "github.com/golang/protobuf/proto"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Fields redacted when no redaction rule is configured
+var DefaultRedactedFieldPattern = regexp.MustCompile(
+	`(?i)password|passwd|secret|token|credential|api_?key|authorization|private_?key`)
+
+// Fields replaced by [REDACTED] when payloads are logged
+type PayloadRedaction struct {
+	// Field names, proto or json, compared case insensitively, ex: "password" or "refreshToken"
+	Fields []string
+	// Patterns matched against the proto and json names of the fields
+	FieldPatterns []*regexp.Regexp
+	// Boolean field option marking sensitive fields, ex: the E_Sensitive of
+	// `extend google.protobuf.FieldOptions { bool sensitive = 50000; }`
+	Option protoreflect.ExtensionType
+}
+
+// Settings of the payload logs, see WithPayloadLogging
+type PayloadLogConfig struct {
+	// DefaultRedactedFieldPattern is used when no rule is set. Also applies to the payloads sampled by the access logs
+	Redaction PayloadRedaction
+	// Logged payloads are truncated to this many bytes, 4KB when 0. Also applies to the sampled payloads
+	MaxSize int
+	// Level of the payload logs, debug by default
+	Level zerolog.Level
+}
+
+type payloadRenderer struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+	option   protoreflect.ExtensionType
+	maxSize  int
+}
+
+func newPayloadRenderer(redaction PayloadRedaction, maxSize int) *payloadRenderer {
+	if len(redaction.Fields) == 0 && len(redaction.FieldPatterns) == 0 && redaction.Option == nil {
+		redaction.FieldPatterns = []*regexp.Regexp{DefaultRedactedFieldPattern}
+	}
+
+	if maxSize == 0 {
+		maxSize = defaultMaxLoggedPayloadSize
+	}
+
+	fields := map[string]bool{}
+	for _, field := range redaction.Fields {
+		fields[strings.ToLower(field)] = true
+	}
+
+	return &payloadRenderer{
+		fields:   fields,
+		patterns: redaction.FieldPatterns,
+		option:   redaction.Option,
+		maxSize:  maxSize,
+	}
+}
+
+// Renders protobuf messages as json with the sensitive fields redacted, truncated to the max size.
+// Empty when m is not a protobuf message
+func (r *payloadRenderer) render(m interface{}) string {
+	message, ok := m.(protoV1.Message)
+	if !ok {
+		return ""
+	}
+
+	messageV2 := protoV1.MessageV2(message)
+
+	payload, err := protojson.Marshal(messageV2)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	// the json tree is redacted rather than the message so every field type can be replaced by a string
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	payload, err = json.Marshal(r.redact(tree, messageV2.ProtoReflect().Descriptor()))
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	if len(payload) > r.maxSize {
+		// cut before the rune spanning the max size rather than in the middle of it
+		size := r.maxSize
+		for size > 0 && !utf8.RuneStart(payload[size]) {
+			size--
+		}
+
+		return string(payload[:size]) + "...(truncated)"
+	}
+
+	return string(payload)
+}
+
+// Redacts the json value of a message of type descriptor. Without descriptor, ex: inside a google.protobuf.Struct,
+// only the field names are checked
+func (r *payloadRenderer) redact(value interface{}, descriptor protoreflect.MessageDescriptor) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redact(v[i], descriptor)
+		}
+	case map[string]interface{}:
+		for name, fieldValue := range v {
+			var field protoreflect.FieldDescriptor
+			if descriptor != nil {
+				field = descriptor.Fields().ByJSONName(name)
+				if field == nil {
+					field = descriptor.Fields().ByName(protoreflect.Name(name))
+				}
+			}
+
+			switch {
+			case r.isRedacted(name, field):
+				v[name] = redactedValue
+			case field == nil:
+				v[name] = r.redact(fieldValue, nil)
+			case field.IsMap():
+				if entries, ok := fieldValue.(map[string]interface{}); ok {
+					for key, entry := range entries {
+						entries[key] = r.redact(entry, getJSONMessageDescriptor(field.MapValue()))
+					}
+				}
+			default:
+				v[name] = r.redact(fieldValue, getJSONMessageDescriptor(field))
+			}
+		}
+	}
+
+	return value
+}
+
+func (r *payloadRenderer) isRedacted(name string, field protoreflect.FieldDescriptor) bool {
+	names := []string{name}
+	if field != nil {
+		names = append(names, string(field.Name()), field.JSONName())
+	}
+
+	for _, name := range names {
+		if r.fields[strings.ToLower(name)] {
+			return true
+		}
+
+		for _, pattern := range r.patterns {
+			if pattern.MatchString(name) {
+				return true
+			}
+		}
+	}
+
+	if field != nil && r.option != nil {
+		options := field.Options()
+		if options != nil && proto.HasExtension(options, r.option) {
+			isSensitive, ok := proto.GetExtension(options, r.option).(bool)
+			return !ok || isSensitive
+		}
+	}
+
+	return false
+}
+
+// Descriptor of the message rendered by field, nil for scalars and well known types which have their own json format
+func getJSONMessageDescriptor(field protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	descriptor := field.Message()
+	if descriptor == nil || descriptor.ParentFile().Package() == "google.protobuf" {
+		return nil
+	}
+
+	return descriptor
+}
+
+// Payloads of a call sampled by the access logs, see AccessLogConfig.PayloadSampleRate
+type sampledPayloads struct {
+	isRendered bool
+	request    string
+	response   string
+}
+
+type sampledPayloadsKey struct{}
+
+// Renders the payloads of the calls sampled by the access logger, which runs before the user interceptors
+// and only reads them once the call completes
+func (r *payloadRenderer) sampleUnaryInterceptor(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+	resp interface{}, err error) {
+	payloads, ok := ctx.Value(sampledPayloadsKey{}).(*sampledPayloads)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	payloads.request = r.render(req)
+	resp, err = handler(ctx, req)
+	payloads.response = r.render(resp)
+	payloads.isRendered = true
+
+	return resp, err
+}
+
+type payloadLogger struct {
+	renderer *payloadRenderer
+	level    zerolog.Level
+}
+
+func (l *payloadLogger) log(ctx context.Context, name string, m interface{}) {
+	// rendering is skipped when the level is disabled
+	if event := log.Ctx(ctx).WithLevel(l.level); event.Enabled() {
+		event.Str("payload", l.renderer.render(m)).Msg(name)
+	}
+}
+
+func (l *payloadLogger) unaryInterceptor(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+	resp interface{}, err error) {
+	l.log(ctx, "grpc request payload", req)
+
+	resp, err = handler(ctx, req)
+	if err == nil {
+		l.log(ctx, "grpc response payload", resp)
+	}
+
+	return resp, err
+}
+
+func (l *payloadLogger) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	return handler(srv, &payloadLoggingServerStream{
+		WrappedServerStream: grpc_middleware.WrapServerStream(ss),
+		logger:              l,
+	})
+}
+
+// Logs every message of a stream
+type payloadLoggingServerStream struct {
+	*grpc_middleware.WrappedServerStream
+	logger *payloadLogger
+}
+
+func (s *payloadLoggingServerStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil {
+		s.logger.log(s.Context(), "grpc stream request payload", m)
+	}
+
+	return err
+}
+
+func (s *payloadLoggingServerStream) SendMsg(m interface{}) error {
+	s.logger.log(s.Context(), "grpc stream response payload", m)
+	return s.WrappedServerStream.SendMsg(m)
+}
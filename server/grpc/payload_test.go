@@ -0,0 +1,265 @@
+package grpc_test
+
+import (
+	"context"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/runtime/protoimpl"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// What protoc-gen-go generates for `extend google.protobuf.FieldOptions { bool sensitive = 50000; }`
+var E_Sensitive = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50000,
+	Name:          "kinto.test.sensitive",
+	Tag:           "varint,50000,opt,name=sensitive",
+	Filename:      "kinto/test/options.proto",
+}
+
+//	message Credentials {
+//	  string user = 1;
+//	  string pin = 2 [(kinto.test.sensitive) = true];
+//	  string api_key = 3;
+//	  Credentials parent = 4;
+//	  repeated Credentials others = 5;
+//	}
+func newCredentialsDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	sensitive := &descriptorpb.FieldOptions{}
+	proto.SetExtension(sensitive, E_Sensitive, true)
+
+	field := func(name string, number int32, options *descriptorpb.FieldOptions) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:    proto.String(name),
+			Number:  proto.Int32(number),
+			Label:   descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:    descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Options: options,
+		}
+	}
+
+	parent := field("parent", 4, nil)
+	parent.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	parent.TypeName = proto.String(".kinto.test.Credentials")
+
+	others := field("others", 5, nil)
+	others.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	others.TypeName = proto.String(".kinto.test.Credentials")
+	others.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("kinto/test/credentials.proto"),
+		Package: proto.String("kinto.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Credentials"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("user", 1, nil), field("pin", 2, sensitive), field("api_key", 3, nil), parent, others,
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.NoError(t, err)
+
+	return file.Messages().Get(0)
+}
+
+// Serves /kinto.test.Credentials/Check which answers with its request
+func startCredentialsServer(
+	t *testing.T, descriptor protoreflect.MessageDescriptor, options ...kintoGrpc.Option) (*grpc.ClientConn, func()) {
+	serviceDesc := &grpc.ServiceDesc{
+		ServiceName: "kinto.test.Credentials",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Check",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := dynamicpb.NewMessage(descriptor)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/kinto.test.Credentials/Check"}
+				return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return req, nil
+				})
+			},
+		}},
+	}
+
+	lis := newLocalListener(t)
+	stop := startServer(t, append([]kintoGrpc.Option{
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			s.RegisterService(serviceDesc, struct{}{})
+		}),
+	}, options...)...)
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	return conn, func() {
+		conn.Close()
+		stop()
+	}
+}
+
+func TestPayloadLogging(t *testing.T) {
+	descriptor := newCredentialsDescriptor(t)
+
+	tests := []struct {
+		name            string
+		config          kintoGrpc.PayloadLogConfig
+		expectedPayload string
+	}{
+		{
+			name: "default pattern",
+			expectedPayload: `{"user":"alice","pin":"1234","apiKey":"[REDACTED]",` +
+				`"parent":{"user":"bob","pin":"5678"},"others":[{"user":"carol"}]}`,
+		},
+		{
+			name: "field names",
+			config: kintoGrpc.PayloadLogConfig{
+				Redaction: kintoGrpc.PayloadRedaction{Fields: []string{"USER", "api_key"}},
+			},
+			expectedPayload: `{"user":"[REDACTED]","pin":"1234","apiKey":"[REDACTED]",` +
+				`"parent":{"user":"[REDACTED]","pin":"5678"},"others":[{"user":"[REDACTED]"}]}`,
+		},
+		{
+			name: "field patterns",
+			config: kintoGrpc.PayloadLogConfig{
+				Redaction: kintoGrpc.PayloadRedaction{FieldPatterns: []*regexp.Regexp{regexp.MustCompile("^(pin|parent)$")}},
+			},
+			expectedPayload: `{"user":"alice","pin":"[REDACTED]","apiKey":"k",` +
+				`"parent":"[REDACTED]","others":[{"user":"carol"}]}`,
+		},
+		{
+			name: "field option",
+			config: kintoGrpc.PayloadLogConfig{
+				Redaction: kintoGrpc.PayloadRedaction{Option: E_Sensitive},
+			},
+			expectedPayload: `{"user":"alice","pin":"[REDACTED]","apiKey":"k",` +
+				`"parent":{"user":"bob","pin":"[REDACTED]"},"others":[{"user":"carol"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := &logBuffer{}
+			logger := zerolog.New(logs)
+			conn, stop := startCredentialsServer(t, descriptor,
+				kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{Logger: &logger}),
+				kintoGrpc.WithPayloadLogging(test.config),
+			)
+			defer stop()
+
+			req := dynamicpb.NewMessage(descriptor)
+			assert.NoError(t, protojson.Unmarshal([]byte(
+				`{"user":"alice","pin":"1234","apiKey":"k","parent":{"user":"bob","pin":"5678"},"others":[{"user":"carol"}]}`,
+			), req))
+
+			assert.NoError(t, conn.Invoke(context.Background(), "/kinto.test.Credentials/Check", req,
+				dynamicpb.NewMessage(descriptor)))
+
+			payloads := logs.entries(t, "grpc request payload", "grpc response payload")
+			assert.Len(t, payloads, 2)
+			for _, payload := range payloads {
+				assert.JSONEq(t, test.expectedPayload, payload["payload"].(string))
+				assert.Equal(t, "debug", payload["level"])
+				assert.Equal(t, "/kinto.test.Credentials/Check", payload["requestName"])
+			}
+		})
+	}
+}
+
+func TestPayloadLogging_Truncation(t *testing.T) {
+	descriptor := newCredentialsDescriptor(t)
+
+	tests := []struct {
+		name            string
+		user            string
+		expectedPayload string
+	}{
+		{
+			name:            "ascii",
+			user:            strings.Repeat("a", 100),
+			expectedPayload: `{"user":"aaaaaaa...(truncated)`,
+		},
+		{
+			// the 16th byte is the second one of the 4th é
+			name:            "multibyte runes",
+			user:            strings.Repeat("é", 100),
+			expectedPayload: `{"user":"ééé...(truncated)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := &logBuffer{}
+			logger := zerolog.New(logs)
+			conn, stop := startCredentialsServer(t, descriptor,
+				kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{Logger: &logger}),
+				kintoGrpc.WithPayloadLogging(kintoGrpc.PayloadLogConfig{MaxSize: 16, Level: zerolog.InfoLevel}),
+			)
+			defer stop()
+
+			req := dynamicpb.NewMessage(descriptor)
+			req.Set(descriptor.Fields().ByName("user"), protoreflect.ValueOfString(test.user))
+			assert.NoError(t, conn.Invoke(context.Background(), "/kinto.test.Credentials/Check", req,
+				dynamicpb.NewMessage(descriptor)))
+
+			payloads := logs.entries(t, "grpc request payload")
+			assert.Len(t, payloads, 1)
+			assert.Equal(t, test.expectedPayload, payloads[0]["payload"])
+			assert.Equal(t, "info", payloads[0]["level"])
+		})
+	}
+}
+
+func TestPayloadLogging_RejectedCalls(t *testing.T) {
+	descriptor := newCredentialsDescriptor(t)
+	logs := &logBuffer{}
+	logger := zerolog.New(logs)
+	conn, stop := startCredentialsServer(t, descriptor,
+		kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{Logger: &logger}),
+		kintoGrpc.WithPayloadLogging(kintoGrpc.PayloadLogConfig{}),
+		// an authentication interceptor rejecting every call
+		kintoGrpc.WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			return nil, status.Error(codes.Unauthenticated, "missing token")
+		}),
+	)
+	defer stop()
+
+	req := dynamicpb.NewMessage(descriptor)
+	err := conn.Invoke(context.Background(), "/kinto.test.Credentials/Check", req, dynamicpb.NewMessage(descriptor))
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Empty(t, logs.entries(t, "grpc request payload", "grpc response payload"))
+}
+
+func TestPayloadLogging_Disabled(t *testing.T) {
+	descriptor := newCredentialsDescriptor(t)
+	logs := &logBuffer{}
+	logger := zerolog.New(logs)
+	conn, stop := startCredentialsServer(t, descriptor,
+		kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{Logger: &logger}))
+	defer stop()
+
+	req := dynamicpb.NewMessage(descriptor)
+	assert.NoError(t, conn.Invoke(context.Background(), "/kinto.test.Credentials/Check", req, req))
+
+	assert.Empty(t, logs.entries(t, "grpc request payload"))
+}
@@ -0,0 +1,193 @@
+package grpc_test
+
+import (
+	"context"
+	"github.com/kintohub/utils-go/server"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Answers with the request id of the call, or the answer of upstream when set
+type requestIdServer struct {
+	longrunning.UnimplementedOperationsServer
+	upstream longrunning.OperationsClient
+}
+
+func (s *requestIdServer) GetOperation(
+	ctx context.Context, req *longrunning.GetOperationRequest) (*longrunning.Operation, error) {
+	if req.Name == "fail" {
+		return nil, kintoGrpc.ConvertToGrpcError(ctx, server.NewError(server.StatusCode_NotFound, "no such operation"))
+	}
+
+	if s.upstream != nil {
+		return s.upstream.GetOperation(ctx, req)
+	}
+
+	return &longrunning.Operation{Name: kintoGrpc.RequestIDFromContext(ctx)}, nil
+}
+
+func startRequestIdServer(
+	t *testing.T, upstream longrunning.OperationsClient, options ...kintoGrpc.Option) (
+	longrunning.OperationsClient, func()) {
+	lis := newLocalListener(t)
+	stop := startServer(t, append([]kintoGrpc.Option{
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			longrunning.RegisterOperationsServer(s, &requestIdServer{upstream: upstream})
+		}),
+	}, options...)...)
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	return longrunning.NewOperationsClient(conn), func() {
+		conn.Close()
+		stop()
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	client, stop := startRequestIdServer(t, nil)
+	defer stop()
+
+	tests := []struct {
+		name              string
+		incomingRequestId string
+		isReused          bool
+	}{
+		{
+			name:              "incoming request id",
+			incomingRequestId: "edge-42",
+			isReused:          true,
+		},
+		{
+			name: "generated request id",
+		},
+		{
+			name:              "too long request id",
+			incomingRequestId: strings.Repeat("a", 129),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.incomingRequestId != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", test.incomingRequestId)
+			}
+
+			header, trailer := metadata.MD{}, metadata.MD{}
+			operation, err := client.GetOperation(
+				ctx, &longrunning.GetOperationRequest{}, grpc.Header(&header), grpc.Trailer(&trailer))
+			assert.NoError(t, err)
+
+			if test.isReused {
+				assert.Equal(t, test.incomingRequestId, operation.Name)
+			} else {
+				// a new uuid
+				assert.Len(t, operation.Name, 36)
+			}
+			assert.Equal(t, []string{operation.Name}, header.Get("x-request-id"))
+			assert.Equal(t, []string{operation.Name}, trailer.Get("x-request-id"))
+		})
+	}
+}
+
+func TestRequestId_ErrorResponse(t *testing.T) {
+	client, stop := startRequestIdServer(t, nil)
+	defer stop()
+
+	header, trailer := metadata.MD{}, metadata.MD{}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "edge-42")
+	_, err := client.GetOperation(
+		ctx, &longrunning.GetOperationRequest{Name: "fail"}, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"edge-42"}, header.Get("x-request-id"))
+	assert.Equal(t, []string{"edge-42"}, trailer.Get("x-request-id"))
+}
+
+func TestRequestId_Gateway(t *testing.T) {
+	lis := newLocalListener(t)
+	stop := startGatewayServer(t, kintoGrpc.WithGrpcWeb(false), kintoGrpc.WithGatewayListener(lis))
+	defer stop()
+
+	tests := []struct {
+		name              string
+		path              string
+		incomingRequestId string
+		expectedStatus    int
+	}{
+		{
+			name:              "incoming request id",
+			path:              "/v1/operations/builds/42",
+			incomingRequestId: "edge-42",
+			expectedStatus:    200,
+		},
+		{
+			name:           "generated request id",
+			path:           "/v1/operations/builds/42",
+			expectedStatus: 200,
+		},
+		{
+			name:              "error response",
+			path:              "/v1/operations/missing",
+			incomingRequestId: "edge-42",
+			expectedStatus:    404,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://"+lis.Addr().String()+test.path, nil)
+			assert.NoError(t, err)
+			if test.incomingRequestId != "" {
+				req.Header.Set("X-Request-Id", test.incomingRequestId)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode)
+			if test.incomingRequestId != "" {
+				assert.Equal(t, test.incomingRequestId, resp.Header.Get("Grpc-Metadata-X-Request-Id"))
+			} else {
+				// a new uuid
+				assert.Len(t, resp.Header.Get("Grpc-Metadata-X-Request-Id"), 36)
+			}
+		})
+	}
+}
+
+func TestRequestId_CustomHeader(t *testing.T) {
+	client, stop := startRequestIdServer(t, nil, kintoGrpc.WithRequestIdHeader("X-Correlation-Id"))
+	defer stop()
+
+	header := metadata.MD{}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-correlation-id", "edge-42")
+	operation, err := client.GetOperation(ctx, &longrunning.GetOperationRequest{}, grpc.Header(&header))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "edge-42", operation.Name)
+	assert.Equal(t, []string{"edge-42"}, header.Get("x-correlation-id"))
+}
+
+func TestRequestId_ForwardedByClients(t *testing.T) {
+	// the frontend calls the backend with a connection of CreateConnectionOrDie
+	backend, stopBackend := startRequestIdServer(t, nil)
+	defer stopBackend()
+	frontend, stop := startRequestIdServer(t, backend)
+	defer stop()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "edge-42")
+	operation, err := frontend.GetOperation(ctx, &longrunning.GetOperationRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "edge-42", operation.Name)
+}
@@ -1,73 +1,514 @@
 package grpc
 
 import (
-	"fmt"
+	"context"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/kintohub/utils-go/config"
+	"github.com/kintohub/utils-go/metrics"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// same as grpc
+	defaultMaxRecvMsgSize = 4 * 1024 * 1024
 )
 
 type RegisterServiceHandler func(s *grpc.Server)
 
-func RunServer(grpcPort, grpcWebPort, corsAllowedHosts string, handlers ...RegisterServiceHandler) {
-	server := grpc.NewServer(
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			unaryEnrichCallInterceptor,
-			unaryLoggingInterceptor,
-			grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandler(panicRecoveryHandler)),
-		)),
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			streamEnrichCallInterceptor,
-			streamLoggingInterceptor,
-			grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandler(panicRecoveryHandler)),
-		)),
+// A grpc server with the KintoHub middlewares, optionally also serving grpc-web
+type Server struct {
+	grpcServer    *grpc.Server
+	httpServer    *http.Server
+	gatewayServer *http.Server
+	gatewayConn   *grpc.ClientConn
+	shutdown      chan struct{}
+	shutdownOnce  sync.Once
+	health        *healthService
+
+	grpcPort                 string
+	grpcListener             net.Listener
+	grpcWebEnabled           bool
+	grpcWebPort              string
+	grpcWebListener          net.Listener
+	grpcWebOptions           []grpcweb.Option
+	gatewayPort              string
+	gatewayListener          net.Listener
+	gatewayPathPrefix        string
+	maxRecvMsgSize           int
+	cors                     CorsConfig
+	corsAllowedHosts         []string
+	websocket                *WebsocketConfig
+	requestIdHeader          string
+	accessLog                AccessLogConfig
+	payloadLog               *PayloadLogConfig
+	metrics                  *ServerMetrics
+	metricsServer            *http.Server
+	metricsPort              string
+	metricsListener          net.Listener
+	metricsGatherer          prometheus.Gatherer
+	authenticator            *Authenticator
+	authorizer               *Authorizer
+	unaryInterceptors        []grpc.UnaryServerInterceptor
+	streamInterceptors       []grpc.StreamServerInterceptor
+	serverOptions            []grpc.ServerOption
+	handlers                 []RegisterServiceHandler
+	shutdownTimeout          time.Duration
+	reflectionEnabled        bool
+	channelzEnabled          bool
+	requestValidationEnabled bool
+	certFiles                *reloadingFiles
+	clientCAFile             *reloadingFiles
+	tlsReloadInterval        time.Duration
+}
+
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		shutdown:                 make(chan struct{}),
+		grpcWebEnabled:           true,
+		requestValidationEnabled: true,
+		shutdownTimeout:          defaultShutdownTimeout,
+		cors:                     defaultCorsConfig(),
+		requestIdHeader:          DefaultRequestIdHeader,
+		maxRecvMsgSize:           defaultMaxRecvMsgSize,
+		health:                   newHealthService(),
+		// env vars so every service can be introspected without code changes
+		reflectionEnabled: config.GetBool("GRPC_REFLECTION_ENABLED", false),
+		channelzEnabled:   config.GetBool("GRPC_CHANNELZ_ENABLED", false),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	// options are resolved once they are all applied so their order does not matter
+	if s.corsAllowedHosts != nil {
+		s.cors.AllowedOrigins = s.corsAllowedHosts
+	}
+
+	// browsers forwarding request ids would otherwise fail the preflight
+	s.cors.AllowedHeaders = append(append([]string{}, s.cors.AllowedHeaders...), s.requestIdHeader)
+
+	if s.tlsReloadInterval > 0 {
+		for _, files := range []*reloadingFiles{s.certFiles, s.clientCAFile} {
+			if files != nil {
+				files.checkInterval = s.tlsReloadInterval
+			}
+		}
+	}
+
+	accessLogger := newAccessLogger(s.accessLog)
+
+	unaryInterceptors := []grpc.UnaryServerInterceptor{newUnaryEnrichCallInterceptor(s.requestIdHeader)}
+	streamInterceptors := []grpc.StreamServerInterceptor{newStreamEnrichCallInterceptor(s.requestIdHeader)}
+
+	// before the recovery so panics are counted as Internal errors
+	if s.metrics != nil {
+		unaryInterceptors = append(unaryInterceptors, s.metrics.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.metrics.StreamServerInterceptor())
+	}
+
+	unaryInterceptors = append(unaryInterceptors,
+		accessLogger.unaryInterceptor,
+		grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandler(panicRecoveryHandler)),
 	)
+	streamInterceptors = append(streamInterceptors,
+		accessLogger.streamInterceptor,
+		grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandler(panicRecoveryHandler)),
+	)
+
+	// the user interceptors only see authenticated and authorized calls
+	if s.authenticator != nil {
+		unaryInterceptors = append(unaryInterceptors, s.authenticator.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.authenticator.StreamServerInterceptor())
+	}
+
+	if s.authorizer != nil {
+		unaryInterceptors = append(unaryInterceptors, s.authorizer.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.authorizer.StreamServerInterceptor())
+	}
+
+	unaryInterceptors = append(unaryInterceptors, s.unaryInterceptors...)
+	streamInterceptors = append(streamInterceptors, s.streamInterceptors...)
 
-	for _, handler := range handlers {
-		handler(server)
+	// after the user interceptors so the payloads of the calls they reject, ex: unauthenticated, are not logged
+	renderer := newPayloadRenderer(PayloadRedaction{}, 0)
+	if s.payloadLog != nil {
+		renderer = newPayloadRenderer(s.payloadLog.Redaction, s.payloadLog.MaxSize)
 	}
 
-	go runGrpcServer(server, grpcPort)
+	if s.accessLog.PayloadSampleRate > 0 {
+		unaryInterceptors = append(unaryInterceptors, renderer.sampleUnaryInterceptor)
+	}
+
+	if s.payloadLog != nil {
+		payloadLogger := &payloadLogger{renderer: renderer, level: s.payloadLog.Level}
+		unaryInterceptors = append(unaryInterceptors, payloadLogger.unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, payloadLogger.streamInterceptor)
+	}
+
+	// validation goes last so unauthenticated calls are rejected before their content is looked at
+	if s.requestValidationEnabled {
+		unaryInterceptors = append(unaryInterceptors, unaryValidationInterceptor)
+		streamInterceptors = append(streamInterceptors, streamValidationInterceptor)
+	}
+
+	serverOptions := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+	}
 
-	// This must go last as its a blocking call
-	runGrpcWebServer(server, grpcWebPort, corsAllowedHosts)
+	if s.certFiles != nil {
+		var creds credentials.TransportCredentials = credentials.NewTLS(newServerTLSConfig(s.certFiles, s.clientCAFile))
+		// Run refuses to start the gateway with mTLS, it has no client certificate
+		if s.isGatewayEnabled() && s.clientCAFile == nil {
+			creds = &inProcessCredentials{TransportCredentials: creds}
+		}
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	}
+
+	s.grpcServer = grpc.NewServer(append(serverOptions, s.serverOptions...)...)
+
+	for _, handler := range s.handlers {
+		handler(s.grpcServer)
+	}
+
+	s.health.register(s.grpcServer)
+
+	if s.reflectionEnabled {
+		reflection.Register(s.grpcServer)
+	}
+
+	if s.channelzEnabled {
+		channelz.RegisterChannelzServiceToServer(s.grpcServer)
+	}
+
+	return s
+}
+
+// The underlying grpc server, ex: to register more services before calling Run
+func (s *Server) GrpcServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// Registers a named dependency check, see WithHealthChecker. Must be called before Run
+func (s *Server) RegisterHealthChecker(name string, checker HealthChecker) {
+	s.health.checkers[name] = checker
+}
+
+func (s *Server) isGatewayEnabled() bool {
+	return s.gatewayPort != "" || s.gatewayListener != nil || s.gatewayPathPrefix != ""
 }
 
-func runGrpcServer(server *grpc.Server, port string) {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":"+port))
+// Blocking call serving grpc and, when enabled, grpc-web and gateway requests until SIGTERM/SIGINT is received,
+// Shutdown is called or one of the servers fails. Returns nil after a graceful shutdown
+func (s *Server) Run() error {
+	if s.isGatewayEnabled() && s.clientCAFile != nil {
+		return errors.New("the gateway cannot be enabled with a client CA, it has no client certificate")
+	}
+
+	if s.grpcWebEnabled && s.cors.AllowCredentials && s.cors.allowsEveryOrigin() {
+		return errors.New("cors cannot allow credentials from every origin, list the allowed origins instead")
+	}
+
+	// load the certificates upfront so invalid files fail the startup instead of every handshake
+	if s.certFiles != nil {
+		if _, err := s.certFiles.get(); err != nil {
+			return errors.Wrap(err, "could not load tls certificate")
+		}
+
+		if s.clientCAFile != nil {
+			if _, err := s.clientCAFile.get(); err != nil {
+				return errors.Wrap(err, "could not load tls client CA")
+			}
+		}
+	}
+
+	grpcListener, err := listen(s.grpcPort, s.grpcListener)
 	if err != nil {
-		log.Panic().Err(err).Msgf("failed to listen to tcp port.")
+		return errors.Wrap(err, "failed to listen for grpc connections")
+	}
+
+	var grpcWebListener net.Listener
+	if s.grpcWebEnabled {
+		grpcWebListener, err = listen(s.grpcWebPort, s.grpcWebListener)
+		if err != nil {
+			_ = grpcListener.Close()
+			return errors.Wrap(err, "failed to listen for grpc-web connections")
+		}
+	}
+
+	var gatewayListener net.Listener
+	if s.gatewayPort != "" || s.gatewayListener != nil {
+		gatewayListener, err = listen(s.gatewayPort, s.gatewayListener)
+		if err != nil {
+			closeListeners(grpcListener, grpcWebListener)
+			return errors.Wrap(err, "failed to listen for gateway connections")
+		}
+	}
+
+	var metricsListener net.Listener
+	if s.metricsPort != "" || s.metricsListener != nil {
+		metricsListener, err = listen(s.metricsPort, s.metricsListener)
+		if err != nil {
+			closeListeners(grpcListener, grpcWebListener, gatewayListener)
+			return errors.Wrap(err, "failed to listen for metrics requests")
+		}
 	}
 
-	log.Info().Msgf("Listening to :%s for grpc connection requests", port)
+	// publish the initial health before accepting connections so probes never see an unknown status
+	serviceNames := s.getServiceNames()
+	s.health.check(context.Background(), serviceNames)
+
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	go s.health.run(healthCtx, serviceNames)
+
+	// buffered so the servers never block when nobody is waiting anymore
+	serveErrors := make(chan error, 5)
+
+	var gatewayHandler http.Handler
+	if s.isGatewayEnabled() {
+		// the gateway calls the services through the grpc server so every interceptor still applies
+		inProcessListener := newInProcessListener()
+		go func() {
+			serveErrors <- errors.Wrap(s.grpcServer.Serve(inProcessListener), "in process grpc server failed")
+		}()
+
+		s.gatewayConn, err = grpc.Dial("in-process", grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return inProcessListener.Dial()
+			}))
+		if err != nil {
+			s.stop()
+			return errors.Wrap(err, "could not connect the gateway to the grpc server")
+		}
+
+		gatewayHandler = newGateway(serviceNames, s.gatewayConn, s.gatewayPathPrefix, s.requestIdHeader,
+			s.maxRecvMsgSize)
+	}
+
+	go func() {
+		log.Info().Msgf("Listening to %s for grpc connection requests", grpcListener.Addr())
+		serveErrors <- errors.Wrap(s.grpcServer.Serve(grpcListener), "grpc server failed")
+	}()
+
+	if s.grpcWebEnabled {
+		handler := newGrpcWebHandler(s.grpcServer, s.cors, s.websocket, s.grpcWebOptions)
+		if gatewayHandler != nil && gatewayListener == nil {
+			handler = newPathPrefixHandler(s.gatewayPathPrefix, gatewayHandler, handler)
+		}
+		s.httpServer = &http.Server{Handler: newCorsHandler(s.cors, handler)}
+
+		go func() {
+			log.Info().Msgf("Listening to %s for grpc-web connection requests", grpcWebListener.Addr())
+			err := s.httpServer.Serve(grpcWebListener)
+			if err != http.ErrServerClosed {
+				serveErrors <- errors.Wrap(err, "grpc-web server failed")
+			}
+		}()
+	}
+
+	if gatewayListener != nil {
+		s.gatewayServer = &http.Server{Handler: gatewayHandler}
+
+		go func() {
+			log.Info().Msgf("Listening to %s for gateway connection requests", gatewayListener.Addr())
+			err := s.gatewayServer.Serve(gatewayListener)
+			if err != http.ErrServerClosed {
+				serveErrors <- errors.Wrap(err, "gateway server failed")
+			}
+		}()
+	}
 
-	_ = server.Serve(lis)
+	if metricsListener != nil {
+		mux := http.NewServeMux()
+		mux.Handle(metrics.MetricsPath, metrics.Handler(s.metricsGatherer))
+		s.metricsServer = &http.Server{Handler: mux}
+
+		go func() {
+			log.Info().Msgf("Listening to %s for metrics requests", metricsListener.Addr())
+			err := s.metricsServer.Serve(metricsListener)
+			if err != http.ErrServerClosed {
+				serveErrors <- errors.Wrap(err, "metrics server failed")
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		log.Info().Msgf("received %v signal, shutting down gracefully", sig)
+	case <-s.shutdown:
+		log.Info().Msg("shutdown requested, shutting down gracefully")
+	case err := <-serveErrors:
+		s.stop()
+		return err
+	}
+
+	return s.gracefulStop()
+}
+
+// Makes Run stop the servers gracefully and return. Safe to call multiple times
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
 }
 
-func runGrpcWebServer(server *grpc.Server, port, coresAllowedHosts string) {
-	wrappedGrpc := grpcweb.WrapServer(server,
-		grpcweb.WithAllowedRequestHeaders([]string{coresAllowedHosts}),
-		grpcweb.WithOriginFunc(func(origin string) bool {
-			return true
-		}))
+// Stops accepting new connections and waits for in-flight requests up to the shutdown timeout,
+// then closes the remaining connections
+func (s *Server) gracefulStop() error {
+	s.health.shutdown()
 
-	log.Info().Msgf("Listening to :%s for grpc-web connection requests", port)
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	var httpErr error
+	for _, httpServer := range []*http.Server{s.httpServer, s.gatewayServer, s.metricsServer} {
+		if httpServer != nil {
+			if err := httpServer.Shutdown(ctx); err != nil && httpErr == nil {
+				httpErr = err
+			}
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn().Msgf("in-flight requests did not finish within %s, closing connections", s.shutdownTimeout)
+		s.stop()
+		<-stopped
+		return errors.New("graceful shutdown timed out")
+	}
+
+	if s.gatewayConn != nil {
+		_ = s.gatewayConn.Close()
+	}
 
-	panic(http.ListenAndServe(":"+port, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+	if httpErr != nil {
+		s.stop()
+		return errors.Wrap(httpErr, "could not shut down http servers gracefully")
+	}
+
+	log.Info().Msg("server shut down gracefully")
+	return nil
+}
+
+func (s *Server) getServiceNames() []string {
+	var names []string
+	for name := range s.grpcServer.GetServiceInfo() {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Closes all listeners and connections immediately
+func (s *Server) stop() {
+	s.grpcServer.Stop()
+
+	for _, httpServer := range []*http.Server{s.httpServer, s.gatewayServer, s.metricsServer} {
+		if httpServer != nil {
+			_ = httpServer.Close()
+		}
+	}
+
+	if s.gatewayConn != nil {
+		_ = s.gatewayConn.Close()
+	}
+}
+
+// Starts a server with the default settings. Blocks until it is shut down, see Server.Run
+func RunServer(grpcPort, grpcWebPort, corsAllowedHosts string, handlers ...RegisterServiceHandler) error {
+	return NewServer(
+		WithGrpcPort(grpcPort),
+		WithGrpcWebPort(grpcWebPort),
+		WithCorsAllowedHosts(corsAllowedHosts),
+		WithServiceHandlers(handlers...),
+	).Run()
+}
+
+func closeListeners(listeners ...net.Listener) {
+	for _, lis := range listeners {
+		if lis != nil {
+			_ = lis.Close()
+		}
+	}
+}
+
+func listen(port string, lis net.Listener) (net.Listener, error) {
+	if lis != nil {
+		return lis, nil
+	}
+
+	// an empty port would make the system pick a random one
+	if port == "" {
+		return nil, errors.New("no port or listener configured")
+	}
+
+	return net.Listen("tcp", ":"+port)
+}
+
+// Sends the requests under prefix to handler and every other request to fallback
+func newPathPrefixHandler(prefix string, handler, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if hasPathPrefix(req.URL.Path, prefix) {
+			handler.ServeHTTP(resp, req)
+		} else {
+			fallback.ServeHTTP(resp, req)
+		}
+	})
+}
+
+// Cors is handled by newCorsHandler, which wraps this handler. Websockets are only served when configured
+func newGrpcWebHandler(
+	server *grpc.Server, cors CorsConfig, websocket *WebsocketConfig, options []grpcweb.Option) http.Handler {
+	grpcWebOptions := []grpcweb.Option{grpcweb.WithAllowedRequestHeaders(cors.getAllowedHeaders())}
+	if websocket != nil {
+		grpcWebOptions = append(grpcWebOptions, websocket.getGrpcWebOptions(cors)...)
+	}
 
-		resp.Header().Set("Access-Control-Allow-Origin", coresAllowedHosts)
-		resp.Header().Set("Access-Control-Allow-Headers", coresAllowedHosts)
+	wrappedGrpc := grpcweb.WrapServer(server, append(grpcWebOptions, options...)...)
+	exposedHeaders := cors.getExposedHeaders()
 
-		if wrappedGrpc.IsGrpcWebRequest(req) {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if websocket != nil && wrappedGrpc.IsGrpcWebSocketRequest(req) {
+			// grpcweb checks the origin and answers 403 to the ones not allowed
+			wrappedGrpc.ServeHTTP(&readLimitedResponseWriter{ResponseWriter: resp, readLimit: websocket.getReadLimit()}, req)
+		} else if wrappedGrpc.IsGrpcWebRequest(req) {
 			// we assume the handler already take care the status code
-			wrappedGrpc.ServeHTTP(resp, req)
+			wrappedGrpc.HandleGrpcWebRequest(&exposedHeadersResponseWriter{
+				ResponseWriter: resp,
+				exposedHeaders: exposedHeaders,
+			}, req)
 		} else {
-			// handle OPTION requests to always return 200
+			// other requests, ex: load balancer probes, always return 200
 			resp.WriteHeader(http.StatusOK)
 		}
-	})))
+	})
 }
@@ -0,0 +1,377 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newLocalListener(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	return lis
+}
+
+// Runs the server with the options, returns the function shutting it down and waiting for Run to return
+func startServer(t *testing.T, options ...kintoGrpc.Option) func() {
+	server := kintoGrpc.NewServer(options...)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- server.Run()
+	}()
+
+	return func() {
+		// the http servers wait up to 5 seconds for the connections the test requests opened but did not use
+		http.DefaultClient.CloseIdleConnections()
+		server.Shutdown()
+
+		select {
+		case err := <-result:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Error("server did not shut down")
+		}
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWebListener(newLocalListener(t)),
+		kintoGrpc.WithShutdownTimeout(time.Second),
+	)
+
+	stop()
+}
+
+// Unary and server stream calls answering once release is closed or their context is done
+func newBlockingServiceDesc(started chan<- struct{}, release <-chan struct{}) *grpc.ServiceDesc {
+	wait := func(ctx context.Context) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return &grpc.ServiceDesc{
+		ServiceName: "kinto.test.Blocking",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Wait",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &wrapperspb.StringValue{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+
+				return req, wait(ctx)
+			},
+		}},
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Watch",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &wrapperspb.StringValue{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+
+				if err := wait(stream.Context()); err != nil {
+					return err
+				}
+
+				return stream.SendMsg(req)
+			},
+		}},
+	}
+}
+
+// Starts a unary and a stream call on the blocking service, returns their results once they complete
+func startBlockingCalls(t *testing.T, conn *grpc.ClientConn, started <-chan struct{}) (<-chan error, <-chan error) {
+	unaryResult := make(chan error, 1)
+	go func() {
+		unaryResult <- conn.Invoke(context.Background(), "/kinto.test.Blocking/Wait",
+			&wrapperspb.StringValue{Value: "unary"}, &wrapperspb.StringValue{})
+	}()
+
+	streamResult := make(chan error, 1)
+	go func() {
+		stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true},
+			"/kinto.test.Blocking/Watch")
+		if err == nil {
+			err = stream.SendMsg(&wrapperspb.StringValue{Value: "stream"})
+		}
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		if err == nil {
+			err = stream.RecvMsg(&wrapperspb.StringValue{})
+		}
+		if err == nil {
+			err = stream.RecvMsg(&wrapperspb.StringValue{})
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		streamResult <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the calls did not start")
+		}
+	}
+
+	return unaryResult, streamResult
+}
+
+func TestServer_ShutdownDrainsCalls(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	lis := newLocalListener(t)
+	server := kintoGrpc.NewServer(
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithShutdownTimeout(5*time.Second),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			s.RegisterService(newBlockingServiceDesc(started, release), struct{}{})
+		}),
+	)
+
+	result := make(chan error)
+	go func() {
+		result <- server.Run()
+	}()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	defer conn.Close()
+
+	unaryResult, streamResult := startBlockingCalls(t, conn, started)
+	server.Shutdown()
+
+	// the server waits for the in-flight calls
+	select {
+	case err := <-result:
+		t.Fatalf("the server stopped before the calls completed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	assert.NoError(t, <-unaryResult)
+	assert.NoError(t, <-streamResult)
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	lis := newLocalListener(t)
+	server := kintoGrpc.NewServer(
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithShutdownTimeout(100*time.Millisecond),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			s.RegisterService(newBlockingServiceDesc(started, release), struct{}{})
+		}),
+	)
+
+	result := make(chan error)
+	go func() {
+		result <- server.Run()
+	}()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	defer conn.Close()
+
+	unaryResult, streamResult := startBlockingCalls(t, conn, started)
+	server.Shutdown()
+
+	select {
+	case err := <-result:
+		assert.EqualError(t, err, "graceful shutdown timed out")
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	// the calls still running were stopped with their connection
+	assert.Equal(t, grpcCodes.Unavailable, grpcStatus.Code(<-unaryResult))
+	assert.Equal(t, grpcCodes.Unavailable, grpcStatus.Code(<-streamResult))
+}
+
+func TestServer_EmptyPort(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       []kintoGrpc.Option
+		expectedError string
+	}{
+		{
+			name:          "grpc",
+			options:       []kintoGrpc.Option{kintoGrpc.WithGrpcWeb(false)},
+			expectedError: "failed to listen for grpc connections: no port or listener configured",
+		},
+		{
+			name:          "grpc-web enabled by default",
+			options:       []kintoGrpc.Option{kintoGrpc.WithGrpcListener(newLocalListener(t))},
+			expectedError: "failed to listen for grpc-web connections: no port or listener configured",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.EqualError(t, kintoGrpc.NewServer(test.options...).Run(), test.expectedError)
+		})
+	}
+}
+
+func TestServer_AuthOptionOrder(t *testing.T) {
+	authenticator, err := kintoGrpc.NewAuthenticator(kintoGrpc.WithHMACSecret("", testSecret))
+	assert.NoError(t, err)
+	authorizer := kintoGrpc.NewAuthorizer(kintoGrpc.AuthorizationPolicies{
+		"/google.longrunning.Operations/GetOperation": {Scopes: []string{"operations:read"}},
+	})
+
+	var hasClaims bool
+	// the reverse of the order the interceptors run in
+	client, stop := startRequestIdServer(t, nil,
+		kintoGrpc.WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			_, hasClaims = kintoGrpc.ClaimsFromContext(ctx)
+			return handler(ctx, req)
+		}),
+		kintoGrpc.WithAuthorizer(authorizer),
+		kintoGrpc.WithAuthenticator(authenticator),
+	)
+	defer stop()
+
+	token := signToken(t, map[string]interface{}{"exp": 9999999999, "scope": "operations:read"})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = client.GetOperation(ctx, &longrunning.GetOperationRequest{})
+
+	assert.NoError(t, err)
+	assert.True(t, hasClaims)
+}
+
+func TestServer_Health(t *testing.T) {
+	lis := newLocalListener(t)
+	dbErr := errors.New("db down")
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithHealthChecker("db", func(ctx context.Context) error { return dbErr }),
+		kintoGrpc.WithHealthChecker("tasks", func(ctx context.Context) error { return nil }),
+	)
+	defer stop()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	tests := map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":      healthpb.HealthCheckResponse_NOT_SERVING,
+		"db":    healthpb.HealthCheckResponse_NOT_SERVING,
+		"tasks": healthpb.HealthCheckResponse_SERVING,
+	}
+
+	for service, expected := range tests {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, resp.Status, service)
+	}
+}
+
+// Request message with a Validate method, as generated by protoc-gen-validate
+type validatedRequest struct {
+	wrapperspb.StringValue
+}
+
+func (r *validatedRequest) Validate() error {
+	return validation.Validate(r.Value, validation.Required)
+}
+
+func TestServer_RequestValidation(t *testing.T) {
+	var handled []string
+	serviceDesc := &grpc.ServiceDesc{
+		ServiceName: "kinto.test.Validated",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Get",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &validatedRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/kinto.test.Validated/Get"}
+				return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					value := req.(*validatedRequest).Value
+					handled = append(handled, value)
+					return &wrapperspb.StringValue{Value: value}, nil
+				})
+			},
+		}},
+	}
+
+	logs := &logBuffer{}
+	logger := zerolog.New(logs)
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithAccessLog(kintoGrpc.AccessLogConfig{Logger: &logger}),
+		kintoGrpc.WithServiceHandlers(func(s *grpc.Server) {
+			s.RegisterService(serviceDesc, struct{}{})
+		}),
+	)
+	defer stop()
+
+	conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), false)
+	defer conn.Close()
+
+	resp := &wrapperspb.StringValue{}
+	err := conn.Invoke(context.Background(), "/kinto.test.Validated/Get", &wrapperspb.StringValue{}, resp)
+	assert.Equal(t, grpcCodes.InvalidArgument, grpcStatus.Code(err))
+
+	err = conn.Invoke(context.Background(), "/kinto.test.Validated/Get", &wrapperspb.StringValue{Value: "valid"}, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, "valid", resp.Value)
+
+	// invalid requests never reach the handler
+	assert.Equal(t, []string{"valid"}, handled)
+
+	// caused by the client, so not logged as errors
+	invalidLogs := logs.entries(t, "invalid request")
+	assert.Len(t, invalidLogs, 1)
+	assert.Equal(t, "debug", invalidLogs[0]["level"])
+	assert.NotEmpty(t, invalidLogs[0]["requestId"])
+}
@@ -0,0 +1,148 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes, see WithTLSReloadInterval
+const defaultTLSReloadInterval = 10 * time.Second
+
+// Keeps the parsed content of files in memory and parses them again when they change on disk,
+// so rotated certificates (ex: mounted kubernetes secrets) are picked up without a restart
+type reloadingFiles struct {
+	files         []string
+	parse         func() (interface{}, error)
+	checkInterval time.Duration
+
+	mutex     sync.Mutex
+	value     interface{}
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newReloadingFiles(parse func() (interface{}, error), files ...string) *reloadingFiles {
+	return &reloadingFiles{
+		files:         files,
+		parse:         parse,
+		checkInterval: defaultTLSReloadInterval,
+	}
+}
+
+// Returns the latest successfully parsed value. A failed reload keeps serving the previous value
+func (r *reloadingFiles) get() (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.value != nil && time.Since(r.lastCheck) < r.checkInterval {
+		return r.value, nil
+	}
+
+	r.lastCheck = time.Now()
+	modTime, err := r.getLatestModTime()
+
+	if err == nil && r.value != nil && !modTime.After(r.modTime) {
+		return r.value, nil
+	}
+
+	value, parseErr := r.parse()
+
+	if parseErr != nil {
+		if r.value != nil {
+			log.Error().Err(parseErr).Msgf("could not reload %v, keeping the previous version", r.files)
+			return r.value, nil
+		}
+		return nil, parseErr
+	}
+
+	if r.value != nil {
+		log.Info().Msgf("reloaded %v", r.files)
+	}
+
+	r.value = value
+	r.modTime = modTime
+	return r.value, nil
+}
+
+func (r *reloadingFiles) getLatestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func newCertificateFiles(certFile, keyFile string) *reloadingFiles {
+	return newReloadingFiles(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+}
+
+func newCertPoolFile(caFile string) *reloadingFiles {
+	return newReloadingFiles(func() (interface{}, error) {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in %s", caFile)
+		}
+		return pool, nil
+	}, caFile)
+}
+
+// Server side tls config serving the certificate of certFiles. Client certificates are required and
+// verified against the CA of clientCAFile when set (mTLS)
+func newServerTLSConfig(certFiles, clientCAFile *reloadingFiles) *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := certFiles.get()
+		if err != nil {
+			return nil, err
+		}
+		return cert.(*tls.Certificate), nil
+	}
+
+	if clientCAFile == nil {
+		return &tls.Config{
+			GetCertificate: getCertificate,
+		}
+	}
+
+	// a new config per handshake so a reloaded client CA is used right away
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := clientCAFile.get()
+			if err != nil {
+				return nil, err
+			}
+
+			return &tls.Config{
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      pool.(*x509.CertPool),
+				NextProtos:     []string{"h2"},
+			}, nil
+		},
+	}
+}
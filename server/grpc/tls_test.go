@@ -0,0 +1,138 @@
+package grpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a certificate and its key signed by parent (self signed when nil) and returns their paths
+func writeCertificate(t *testing.T, dir, name string, isCA bool, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile, cert, key
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile, _, ca, caKey := writeCertificate(t, dir, "ca", true, nil, nil)
+	serverCert, serverKey, _, _ := writeCertificate(t, dir, "server", false, ca, caKey)
+	clientCert, clientKey, _, _ := writeCertificate(t, dir, "client", false, ca, caKey)
+
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithTLS(serverCert, serverKey),
+		kintoGrpc.WithClientCA(caFile),
+	)
+	defer stop()
+
+	check := func(options ...kintoGrpc.ClientOption) error {
+		conn := kintoGrpc.CreateConnectionOrDie(lis.Addr().String(), true, options...)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	assert.NoError(t, check(kintoGrpc.WithRootCA(caFile), kintoGrpc.WithClientCertificate(clientCert, clientKey)))
+	assert.Error(t, check(kintoGrpc.WithRootCA(caFile)), "client certificate is required")
+	assert.Error(t, check(kintoGrpc.WithClientCertificate(clientCert, clientKey)), "server CA is not trusted")
+}
+
+func TestServer_TLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, _, ca, caKey := writeCertificate(t, dir, "ca", true, nil, nil)
+	serverCert, serverKey, firstCert, _ := writeCertificate(t, dir, "server", false, ca, caKey)
+
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(lis),
+		kintoGrpc.WithGrpcWeb(false),
+		kintoGrpc.WithTLS(serverCert, serverKey),
+		kintoGrpc.WithTLSReloadInterval(10*time.Millisecond),
+	)
+	defer stop()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	// serial number of the certificate presented by the server
+	getServerSerial := func() *big.Int {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{RootCAs: pool, NextProtos: []string{"h2"}})
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	assert.Equal(t, firstCert.SerialNumber, getServerSerial())
+
+	// overwrites the files like a rotated kubernetes secret
+	_, _, secondCert, _ := writeCertificate(t, dir, "server", false, ca, caKey)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(serverCert, later, later))
+	assert.NoError(t, os.Chtimes(serverKey, later, later))
+
+	assert.Eventually(t, func() bool {
+		serial := getServerSerial()
+		return serial != nil && serial.Cmp(secondCert.SerialNumber) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+}
@@ -1,20 +1,159 @@
 package grpc
 
 import (
+	"context"
 	"encoding/json"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/kintohub/utils-go/klog"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	grpcCodes "google.golang.org/grpc/codes"
 	grpcStatus "google.golang.org/grpc/status"
+	"sort"
 )
 
+// Validates any request with a `Validate() error` method, which covers both ozzo's validation.Validatable
+// and messages generated by protoc-gen-validate.
+// Servers validate requests automatically unless WithRequestValidation(false) is set, handlers calling it as well
+// validate and log invalid requests twice
 func ValidateGrpcRequest(v validation.Validatable) error {
-	klog.Debugf("Request received - %v", v)
+	return validateRequest(&log.Logger, v)
+}
 
+// Invalid requests are logged at debug level, the access logs already report their InvalidArgument code
+func validateRequest(logger *zerolog.Logger, v validation.Validatable) error {
 	if err := v.Validate(); err != nil {
-		data, _ := json.Marshal(&err)
-		klog.ErrorWithErr(err, "error during the validation of the request")
-		return grpcStatus.Error(grpcCodes.InvalidArgument, string(data))
+		logger.Debug().Err(err).Msg("invalid request")
+
+		message := err.Error()
+
+		// ozzo field errors are serialized as a json object of field -> error, kept for older clients
+		if fieldErrors, ok := err.(validation.Errors); ok {
+			data, _ := json.Marshal(&fieldErrors)
+			message = string(data)
+		}
+
+		status := grpcStatus.New(grpcCodes.InvalidArgument, message)
+
+		if violations := getFieldViolations("", err); len(violations) > 0 {
+			detailedStatus, detailsErr := status.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+			if detailsErr == nil {
+				status = detailedStatus
+			}
+		}
+
+		return status.Err()
+	}
+
+	return nil
+}
+
+// Returns the field violations of a validation error returned by ValidateGrpcRequest or the validation interceptor,
+// ex: to highlight the invalid fields of a form. Empty when err has none
+func GetFieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	for _, detail := range grpcStatus.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			violations = append(violations, badRequest.FieldViolations...)
+		}
+	}
+
+	return violations
+}
+
+// protoc-gen-validate field errors
+type pgvFieldError interface {
+	Field() string
+	Reason() string
+}
+
+// protoc-gen-validate errors of ValidateAll()
+type pgvMultiError interface {
+	AllErrors() []error
+}
+
+// Flattens nested validation errors into field violations with dot separated paths, ex: "address.city"
+func getFieldViolations(path string, err error) []*errdetails.BadRequest_FieldViolation {
+	joinPath := func(field string) string {
+		if path == "" {
+			return field
+		}
+		return path + "." + field
+	}
+
+	switch e := err.(type) {
+	case validation.Errors:
+		fields := make([]string, 0, len(e))
+		for field := range e {
+			fields = append(fields, field)
+		}
+		// map iteration order is random, keep the details stable
+		sort.Strings(fields)
+
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, field := range fields {
+			violations = append(violations, getFieldViolations(joinPath(field), e[field])...)
+		}
+		return violations
+	case pgvMultiError:
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, fieldErr := range e.AllErrors() {
+			violations = append(violations, getFieldViolations(path, fieldErr)...)
+		}
+		return violations
+	case pgvFieldError:
+		// embedded messages errors carry the error of the nested field as their cause
+		if causer, ok := err.(interface{ Cause() error }); ok && causer.Cause() != nil {
+			if nested := getFieldViolations(joinPath(e.Field()), causer.Cause()); len(nested) > 0 {
+				return nested
+			}
+		}
+
+		return []*errdetails.BadRequest_FieldViolation{{Field: joinPath(e.Field()), Description: e.Reason()}}
+	case validation.InternalError:
+		return nil
+	default:
+		if path == "" {
+			return nil
+		}
+		return []*errdetails.BadRequest_FieldViolation{{Field: path, Description: err.Error()}}
+	}
+}
+
+// Validates requests before they reach the handler so handlers do not need to call ValidateGrpcRequest
+func unaryValidationInterceptor(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+	resp interface{}, err error) {
+
+	if v, ok := req.(validation.Validatable); ok {
+		if err := validateRequest(log.Ctx(ctx), v); err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// Validates every message received by the stream handler
+func streamValidationInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	return handler(srv, &validatingServerStream{ServerStream: ss})
+}
+
+type validatingServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if v, ok := m.(validation.Validatable); ok {
+		return validateRequest(log.Ctx(s.Context()), v)
 	}
 
 	return nil
@@ -0,0 +1,95 @@
+package grpc_test
+
+import (
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city"`
+}
+
+func (a address) Validate() error {
+	return validation.ValidateStruct(&a, validation.Field(&a.City, validation.Required))
+}
+
+type signUpRequest struct {
+	Email   string  `json:"email"`
+	Name    string  `json:"name"`
+	Address address `json:"address"`
+}
+
+func (r *signUpRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Email, validation.Required, is.Email),
+		validation.Field(&r.Name, validation.Required),
+		validation.Field(&r.Address),
+	)
+}
+
+type pgvError struct {
+	field, reason string
+	cause         error
+}
+
+func (e pgvError) Error() string  { return e.field + ": " + e.reason }
+func (e pgvError) Field() string  { return e.field }
+func (e pgvError) Reason() string { return e.reason }
+func (e pgvError) Cause() error   { return e.cause }
+
+type pgvRequest struct{}
+
+func (r *pgvRequest) Validate() error {
+	return pgvError{field: "Address", reason: "embedded message failed validation",
+		cause: pgvError{field: "City", reason: "value length must be at least 1 runes"}}
+}
+
+type failingRequest struct{}
+
+func (r *failingRequest) Validate() error {
+	return errors.New("request is invalid")
+}
+
+func TestValidateGrpcRequest_FieldViolations(t *testing.T) {
+	tests := []struct {
+		name    string
+		request validation.Validatable
+		want    []*errdetails.BadRequest_FieldViolation
+	}{
+		{
+			name:    "ozzo nested errors",
+			request: &signUpRequest{Email: "not-an-email", Name: "kinto"},
+			want: []*errdetails.BadRequest_FieldViolation{
+				{Field: "address.city", Description: "cannot be blank"},
+				{Field: "email", Description: "must be a valid email address"},
+			},
+		},
+		{
+			name:    "protoc-gen-validate embedded error",
+			request: &pgvRequest{},
+			want: []*errdetails.BadRequest_FieldViolation{
+				{Field: "Address.City", Description: "value length must be at least 1 runes"},
+			},
+		},
+		{
+			name:    "error without fields",
+			request: &failingRequest{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := kintoGrpc.ValidateGrpcRequest(tt.request)
+
+			assert.Equal(t, grpcCodes.InvalidArgument, grpcStatus.Code(err))
+			assert.Equal(t, tt.want, kintoGrpc.GetFieldViolations(err))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package grpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/rs/cors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Same as the default max receive message size of grpc
+const defaultWebsocketReadLimit = 4 * 1024 * 1024
+
+var errWebsocketMessageTooLarge = errors.New("websocket message exceeds the read limit")
+
+// Settings of the grpc-web websocket transport, which browsers need for client and bidirectional streams
+type WebsocketConfig struct {
+	// Origins allowed to open websockets, matched like the cors allowed origins which are used when empty.
+	// Requests without an Origin header are only allowed with "*"
+	AllowedOrigins []string
+	// Interval of the pings keeping idle streams open through proxies, at least 1s, no pings when 0.
+	// grpcweb keeps pinging until the client closes the websocket, even once the stream is complete
+	PingInterval time.Duration
+	// Connections sending a websocket message larger than this many bytes are closed, 4MB when 0
+	ReadLimit int64
+}
+
+func (c *WebsocketConfig) getGrpcWebOptions(corsConfig CorsConfig) []grpcweb.Option {
+	allowedOrigins := c.AllowedOrigins
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = corsConfig.AllowedOrigins
+	}
+
+	return []grpcweb.Option{
+		grpcweb.WithWebsockets(true),
+		grpcweb.WithWebsocketOriginFunc(cors.New(cors.Options{AllowedOrigins: allowedOrigins}).OriginAllowed),
+		grpcweb.WithWebsocketPingInterval(c.PingInterval),
+	}
+}
+
+func (c *WebsocketConfig) getReadLimit() int64 {
+	if c.ReadLimit == 0 {
+		return defaultWebsocketReadLimit
+	}
+
+	return c.ReadLimit
+}
+
+// grpcweb does not expose the websocket connection to set its read limit, the hijacked connection enforces it instead
+type readLimitedResponseWriter struct {
+	http.ResponseWriter
+	readLimit int64
+}
+
+func (w *readLimitedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &readLimitedConn{Conn: conn, readLimit: w.readLimit}, rw, nil
+}
+
+// Follows the frames read from a websocket connection and closes it once a message exceeds the read limit
+type readLimitedConn struct {
+	net.Conn
+	readLimit int64
+	// frame header being read and the payload bytes left in the current frame
+	header           []byte
+	remainingPayload int64
+	messageSize      int64
+}
+
+func (c *readLimitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.inspect(p[:n]) {
+		_ = c.Conn.Close()
+		return 0, errWebsocketMessageTooLarge
+	}
+
+	return n, err
+}
+
+// Returns false when the current message exceeds the read limit
+func (c *readLimitedConn) inspect(b []byte) bool {
+	for len(b) > 0 {
+		if c.remainingPayload > 0 {
+			skipped := int64(len(b))
+			if skipped > c.remainingPayload {
+				skipped = c.remainingPayload
+			}
+			c.remainingPayload -= skipped
+			b = b[skipped:]
+			continue
+		}
+
+		c.header = append(c.header, b[0])
+		b = b[1:]
+
+		payloadSize, ok := parseWebsocketFrameHeader(c.header)
+		if !ok {
+			continue
+		}
+
+		opcode, isFinal := c.header[0]&0x0f, c.header[0]&0x80 != 0
+		c.header = c.header[:0]
+		c.remainingPayload = payloadSize
+
+		// control frames can be sent in the middle of a fragmented message
+		if opcode >= 0x8 {
+			continue
+		}
+
+		// continuation frames add to the current message, the other data frames start a new one
+		if opcode != 0 {
+			c.messageSize = 0
+		}
+
+		c.messageSize += payloadSize
+		if c.messageSize > c.readLimit {
+			return false
+		}
+
+		if isFinal {
+			c.messageSize = 0
+		}
+	}
+
+	return true
+}
+
+// Payload size of a websocket frame, false until its header is complete.
+// See https://tools.ietf.org/html/rfc6455#section-5.2
+func parseWebsocketFrameHeader(header []byte) (int64, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	size := int64(header[1] & 0x7f)
+	length := 2
+	switch size {
+	case 126:
+		length += 2
+	case 127:
+		length += 8
+	}
+
+	if header[1]&0x80 != 0 {
+		// masking key
+		length += 4
+	}
+
+	if len(header) < length {
+		return 0, false
+	}
+
+	switch size {
+	case 126:
+		size = int64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		size = int64(binary.BigEndian.Uint64(header[2:10]))
+	}
+
+	return size, true
+}
@@ -0,0 +1,111 @@
+package grpc_test
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	kintoGrpc "github.com/kintohub/utils-go/server/grpc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Returns the url of the health check and the function shutting the server down
+func startWebsocketServer(t *testing.T, config kintoGrpc.WebsocketConfig) (string, func()) {
+	lis := newLocalListener(t)
+	stop := startServer(t,
+		kintoGrpc.WithGrpcListener(newLocalListener(t)),
+		kintoGrpc.WithGrpcWebListener(lis),
+		kintoGrpc.WithCorsAllowedHosts("https://*.kintohub.com"),
+		kintoGrpc.WithGrpcWebsockets(config),
+	)
+
+	return "ws://" + lis.Addr().String() + "/grpc.health.v1.Health/Check", stop
+}
+
+// Dials without Origin header when origin is empty
+func dialWebsocket(url, origin string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+
+	dialer := &websocket.Dialer{Subprotocols: []string{"grpc-websockets"}}
+	return dialer.Dial(url, header)
+}
+
+// Reads the whole grpc-web response until the server closes the websocket
+func readWebsocketResponse(conn *websocket.Conn) []byte {
+	response := &bytes.Buffer{}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return response.Bytes()
+		}
+		response.Write(message)
+	}
+}
+
+func TestGrpcWebsockets(t *testing.T) {
+	url, stop := startWebsocketServer(t, kintoGrpc.WebsocketConfig{PingInterval: time.Minute})
+	defer stop()
+
+	conn, _, err := dialWebsocket(url, "https://app.kintohub.com")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	headers := "content-type: application/grpc-web+proto\r\nx-grpc-web: 1\r\n"
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(headers)))
+	// an empty HealthCheckRequest prefixed by the data control byte, then the end of the client stream
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0, 0, 0, 0, 0, 0}))
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{1}))
+
+	response := readWebsocketResponse(conn)
+
+	// header frame, data frame with status SERVING and trailer frame
+	assert.Equal(t, byte(0x80), response[0])
+	assert.Contains(t, string(response), string([]byte{0, 0, 0, 0, 2, 8, 1}))
+	assert.Contains(t, string(response), "grpc-status: 0")
+}
+
+func TestGrpcWebsockets_Origin(t *testing.T) {
+	url, stop := startWebsocketServer(t, kintoGrpc.WebsocketConfig{})
+	defer stop()
+
+	_, resp, err := dialWebsocket(url, "https://evil.com")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, resp, err = dialWebsocket(url, "")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	url, stopAllowed := startWebsocketServer(t, kintoGrpc.WebsocketConfig{AllowedOrigins: []string{"https://evil.com"}})
+	defer stopAllowed()
+
+	conn, _, err := dialWebsocket(url, "https://evil.com")
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestGrpcWebsockets_ReadLimit(t *testing.T) {
+	url, stop := startWebsocketServer(t, kintoGrpc.WebsocketConfig{ReadLimit: 64})
+	defer stop()
+
+	headers := "content-type: application/grpc-web+proto\r\nx-grpc-web: 1\r\n"
+
+	for _, frameSize := range []int{1024, 32} {
+		// messages larger than the write buffer are sent as several frames
+		dialer := &websocket.Dialer{Subprotocols: []string{"grpc-websockets"}, WriteBufferSize: frameSize}
+		conn, _, err := dialer.Dial(url, http.Header{"Origin": []string{"https://app.kintohub.com"}})
+		assert.NoError(t, err)
+
+		// the headers fit, the first message is too large
+		assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(headers)))
+		assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte{0}, 65)))
+
+		// the connection is closed without any response
+		assert.Empty(t, readWebsocketResponse(conn), frameSize)
+		conn.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/kintohub/utils-go/server"
+	"github.com/valyala/fasthttp"
+)
+
+const jsonContentType = "application/json"
+
+// Encodes the response as json. Nil responses only set a 204 status
+func WriteJSON(ctx *fasthttp.RequestCtx, response interface{}) {
+	if response == nil {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		WriteError(ctx, server.NewInternalErrorWithErr("could not encode the json response", err))
+		return
+	}
+
+	ctx.SetContentType(jsonContentType)
+	ctx.SetBody(body)
+}
+
+// Logs the error and writes it as RFC 7807 problem details with its http status, see server.NewProblem.
+// The message of 5xx errors is replaced so internal details never reach the client
+func WriteError(ctx *fasthttp.RequestCtx, error *server.Error) {
+	server.LogError(GetLogger(ctx), error)
+	writeProblem(ctx, error)
+}
+
+func writeProblem(ctx *fasthttp.RequestCtx, error *server.Error) {
+	problem := server.NewProblem(error, string(ctx.Path()))
+	problem.Extensions["requestId"] = GetRequestId(ctx)
+	body, _ := json.Marshal(problem)
+
+	if retryAfter := error.RetryAfter(); retryAfter != "" {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, retryAfter)
+	}
+
+	ctx.SetStatusCode(problem.Status)
+	ctx.SetContentType(server.ProblemContentType)
+	ctx.SetBody(body)
+}
+
+// Decodes the json body of the request into v, invalid bodies are bad requests
+func DecodeJSONBody(ctx *fasthttp.RequestCtx, v interface{}) *server.Error {
+	if err := json.Unmarshal(ctx.PostBody(), v); err != nil {
+		return server.NewErrorWithErr(server.StatusCode_BadRequest, "the request body is not valid json", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kintohub/utils-go/server"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+const (
+	// Incoming request ids are reused, otherwise one is generated. Always set on the response
+	RequestIdHeader = "X-Request-Id"
+
+	requestIdKey = "requestId"
+	loggerKey    = "logger"
+
+	// longer incoming ids are replaced so clients cannot flood the logs
+	maxRequestIdLength = 128
+)
+
+// Id of the request, see RequestIdHeader
+func GetRequestId(ctx *fasthttp.RequestCtx) string {
+	requestId, _ := ctx.UserValue(requestIdKey).(string)
+	return requestId
+}
+
+// Logger of the request with its id and name, falls back to the global logger
+func GetLogger(ctx *fasthttp.RequestCtx) *zerolog.Logger {
+	if logger, ok := ctx.UserValue(loggerKey).(*zerolog.Logger); ok {
+		return logger
+	}
+
+	return &log.Logger
+}
+
+func requestIdMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		requestId := string(ctx.Request.Header.Peek(RequestIdHeader))
+		if requestId == "" || len(requestId) > maxRequestIdLength {
+			requestId = uuid.New().String()
+		}
+
+		ctx.SetUserValue(requestIdKey, requestId)
+		ctx.Response.Header.Set(RequestIdHeader, requestId)
+
+		next(ctx)
+	}
+}
+
+func loggingMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		logger := log.With().
+			Caller(). // For all calls that do not have errors - simple stack trace
+			Str("requestId", GetRequestId(ctx)).
+			Str("requestName", fmt.Sprintf("%s %s", ctx.Method(), ctx.Path())).
+			Logger()
+		ctx.SetUserValue(loggerKey, &logger)
+
+		logger.Debug().Msg("...starting to process new http request")
+		start := time.Now()
+
+		next(ctx)
+
+		logger.Debug().
+			Int("statusCode", ctx.Response.StatusCode()).
+			Dur("duration", time.Since(start)).
+			Msg("...finished processing http request")
+	}
+}
+
+func recoveryMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			if r := recover(); r != nil {
+				err, ok := r.(error)
+				if !ok {
+					err = fmt.Errorf("%v", r)
+				}
+
+				GetLogger(ctx).Error().
+					Stack().
+					Err(errors.WithStack(err)).
+					Msgf("[IMPORTANT] a uncaught panic occurred: %v", r)
+
+				ctx.Response.Reset()
+				ctx.Response.Header.Set(RequestIdHeader, GetRequestId(ctx))
+				writeProblem(ctx, server.NewError(server.StatusCode_InternalServerError, server.SeriousErrorMessage))
+			}
+		}()
+
+		next(ctx)
+	}
+}
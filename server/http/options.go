@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net"
+	"time"
+)
+
+// Configures a Server created with NewServer
+type Option func(s *Server)
+
+func WithPort(port string) Option {
+	return func(s *Server) {
+		s.port = port
+	}
+}
+
+// Serves on an existing listener instead of the port, ex: an in-memory listener for tests
+func WithListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.listener = listener
+	}
+}
+
+// Runs after the request id, logging and recovery middlewares, in the given order
+func WithMiddlewares(middlewares ...Middleware) Option {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, middlewares...)
+	}
+}
+
+// Maximum time to wait for in-flight requests during a graceful shutdown. 30s by default
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// Keep-alive connections idle for longer are closed, which also bounds how long a shutdown waits for them.
+// 10s by default
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// Requests with a larger body are rejected. 4MB by default
+func WithMaxBodySize(bytes int) Option {
+	return func(s *Server) {
+		s.maxBodySize = bytes
+	}
+}
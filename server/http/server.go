@@ -0,0 +1,210 @@
+package http
+
+import (
+	"github.com/kintohub/utils-go/server"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Handles a json api call. The response is encoded as json with a 200 status, or a 204 when nil,
+// unless the handler sets another status on ctx. Errors are rendered with their http status
+type Handler func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error)
+
+// Wraps the handling of every request, ex: authentication
+type Middleware func(next fasthttp.RequestHandler) fasthttp.RequestHandler
+
+type Server struct {
+	httpServer   *fasthttp.Server
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	connections  map[net.Conn]struct{}
+	connMutex    sync.Mutex
+
+	port            string
+	listener        net.Listener
+	middlewares     []Middleware
+	routes          map[string]map[string]Handler // path -> method -> handler
+	shutdownTimeout time.Duration
+	idleTimeout     time.Duration
+	maxBodySize     int
+}
+
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		shutdown:        make(chan struct{}),
+		connections:     map[net.Conn]struct{}{},
+		routes:          map[string]map[string]Handler{},
+		shutdownTimeout: 30 * time.Second,
+		// keep-alive connections are only closed by shutdown once idle for this long
+		idleTimeout: 10 * time.Second,
+		maxBodySize: fasthttp.DefaultMaxRequestBodySize,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// Registers the handler of a method and exact path, ex: Handle("GET", "/v1/builds", listBuilds).
+// Must be called before Run
+func (s *Server) Handle(method, path string, handler Handler) {
+	if s.routes[path] == nil {
+		s.routes[path] = map[string]Handler{}
+	}
+
+	s.routes[path][strings.ToUpper(method)] = handler
+}
+
+// Blocking call serving requests until SIGTERM/SIGINT is received, Shutdown is called or the server fails.
+// Returns nil after a graceful shutdown
+func (s *Server) Run() error {
+	listener := s.listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", ":"+s.port)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for http connections")
+		}
+	}
+
+	s.httpServer = &fasthttp.Server{
+		Handler:            s.newRequestHandler(),
+		IdleTimeout:        s.idleTimeout,
+		MaxRequestBodySize: s.maxBodySize,
+		ConnState:          s.trackConnection,
+	}
+
+	// buffered so the server never blocks when nobody is waiting anymore
+	serveErrors := make(chan error, 1)
+
+	go func() {
+		log.Info().Msgf("Listening to %s for http connection requests", listener.Addr())
+		serveErrors <- errors.Wrap(s.httpServer.Serve(listener), "http server failed")
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		log.Info().Msgf("received %v signal, shutting down gracefully", sig)
+	case <-s.shutdown:
+		log.Info().Msg("shutdown requested, shutting down gracefully")
+	case err := <-serveErrors:
+		return err
+	}
+
+	return s.gracefulStop()
+}
+
+// Makes Run stop the server gracefully and return. Safe to call multiple times
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+}
+
+// Stops accepting new connections and waits for in-flight requests up to the shutdown timeout,
+// then closes the remaining connections
+func (s *Server) gracefulStop() error {
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.httpServer.Shutdown()
+	}()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			return errors.Wrap(err, "could not shut down http server gracefully")
+		}
+	case <-time.After(s.shutdownTimeout):
+		log.Warn().Msgf("in-flight requests did not finish within %s, closing connections", s.shutdownTimeout)
+		s.closeConnections()
+		return errors.New("graceful shutdown timed out")
+	}
+
+	log.Info().Msg("server shut down gracefully")
+	return nil
+}
+
+// Keeps track of the open connections, fasthttp cannot close them
+func (s *Server) trackConnection(conn net.Conn, state fasthttp.ConnState) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	switch state {
+	case fasthttp.StateNew:
+		s.connections[conn] = struct{}{}
+	case fasthttp.StateHijacked, fasthttp.StateClosed:
+		delete(s.connections, conn)
+	}
+}
+
+func (s *Server) closeConnections() {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	for conn := range s.connections {
+		_ = conn.Close()
+	}
+}
+
+// Builds the middleware chain: request id, logging, recovery, the configured middlewares and finally the routes
+func (s *Server) newRequestHandler() fasthttp.RequestHandler {
+	handler := s.route
+
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+
+	return requestIdMiddleware(loggingMiddleware(recoveryMiddleware(handler)))
+}
+
+func (s *Server) route(ctx *fasthttp.RequestCtx) {
+	methods, ok := s.routes[string(ctx.Path())]
+	if !ok {
+		WriteError(ctx, server.NewErrorf(server.StatusCode_NotFound, "%s does not exist", ctx.Path()))
+		return
+	}
+
+	handler, ok := methods[string(ctx.Method())]
+	if !ok {
+		var allowed []string
+		for method := range methods {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, strings.Join(allowed, ", "))
+		WriteError(ctx, server.NewErrorf(server.StatusCode_MethodNotAllowed,
+			"%s does not support %s", ctx.Path(), ctx.Method()))
+		return
+	}
+
+	response, err := handler(ctx)
+	if err != nil {
+		WriteError(ctx, err)
+		return
+	}
+
+	WriteJSON(ctx, response)
+}
+
+// Starts a server with the default settings. Blocks until it is shut down, see Server.Run
+func RunServer(port string, register func(s *Server)) error {
+	s := NewServer(WithPort(port))
+	register(s)
+	return s.Run()
+}
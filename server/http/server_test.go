@@ -0,0 +1,183 @@
+package http_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kintohub/utils-go/server"
+	kintoHttp "github.com/kintohub/utils-go/server/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type build struct {
+	Name string `json:"name"`
+}
+
+// Returns a client of the server and the function shutting it down
+func startServer(t *testing.T) (*fasthttp.Client, func()) {
+	listener := fasthttputil.NewInmemoryListener()
+
+	s := kintoHttp.NewServer(kintoHttp.WithListener(listener))
+	s.Handle("GET", "/builds", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		return []build{{Name: "api"}}, nil
+	})
+	s.Handle("POST", "/builds", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		b := &build{}
+		if err := kintoHttp.DecodeJSONBody(ctx, b); err != nil {
+			return nil, err
+		}
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		return b, nil
+	})
+	s.Handle("DELETE", "/builds", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		return nil, nil
+	})
+	s.Handle("GET", "/conflict", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		return nil, server.NewError(server.StatusCode_Conflict, "build already running")
+	})
+	s.Handle("GET", "/internal", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		return nil, server.NewInternalErrorWithErr("mongo password is hunter2", errors.New("auth failed"))
+	})
+	s.Handle("GET", "/panic", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		panic("nil map")
+	})
+
+	go func() {
+		_ = s.Run()
+	}()
+
+	return &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return listener.Dial()
+		},
+	}, s.Shutdown
+}
+
+func do(t *testing.T, client *fasthttp.Client, method, path, body string, headers ...string) *fasthttp.Response {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://test" + path)
+	req.Header.SetMethod(method)
+	req.SetBodyString(body)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp := &fasthttp.Response{}
+	assert.NoError(t, client.Do(req, resp))
+	return resp
+}
+
+func decodeError(t *testing.T, resp *fasthttp.Response) map[string]interface{} {
+	body := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &body))
+	return body
+}
+
+func TestServer(t *testing.T) {
+	client, shutdown := startServer(t)
+	defer shutdown()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"json response", "GET", "/builds", "", 200, `[{"name":"api"}]`},
+		{"decoded body and custom status", "POST", "/builds", `{"name":"web"}`, 201, `{"name":"web"}`},
+		{"nil response", "DELETE", "/builds", "", 204, ""},
+		{"client error", "GET", "/conflict", "", 409, ""},
+		{"invalid json", "POST", "/builds", `{`, 400, ""},
+		{"unknown path", "GET", "/nope", "", 404, ""},
+		{"unknown method", "PUT", "/builds", "", 405, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := do(t, client, test.method, test.path, test.body)
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode())
+			assert.NotEmpty(t, string(resp.Header.Peek(kintoHttp.RequestIdHeader)))
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+}
+
+func TestServer_ErrorBody(t *testing.T) {
+	client, shutdown := startServer(t)
+	defer shutdown()
+
+	resp := do(t, client, "GET", "/conflict", "", kintoHttp.RequestIdHeader, "abc-123")
+
+	assert.Equal(t, "abc-123", string(resp.Header.Peek(kintoHttp.RequestIdHeader)))
+	assert.Equal(t, server.ProblemContentType, string(resp.Header.ContentType()))
+	assert.Equal(t, map[string]interface{}{
+		"type":      "about:blank",
+		"title":     "Conflict",
+		"status":    float64(409),
+		"detail":    "build already running",
+		"instance":  "/conflict",
+		"requestId": "abc-123",
+	}, decodeError(t, resp))
+
+	resp = do(t, client, "PUT", "/builds", "")
+	assert.Equal(t, "DELETE, GET, POST", string(resp.Header.Peek("Allow")))
+}
+
+func TestServer_HidesInternalErrors(t *testing.T) {
+	client, shutdown := startServer(t)
+	defer shutdown()
+
+	for _, path := range []string{"/internal", "/panic"} {
+		resp := do(t, client, "GET", path, "")
+
+		assert.Equal(t, 500, resp.StatusCode(), path)
+		assert.Equal(t, server.SeriousErrorMessage, decodeError(t, resp)["detail"], path)
+		assert.NotEmpty(t, string(resp.Header.Peek(kintoHttp.RequestIdHeader)), path)
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	listener := fasthttputil.NewInmemoryListener()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	s := kintoHttp.NewServer(kintoHttp.WithListener(listener), kintoHttp.WithShutdownTimeout(50*time.Millisecond))
+	s.Handle("GET", "/slow", func(ctx *fasthttp.RequestCtx) (interface{}, *server.Error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Run()
+	}()
+
+	conn, err := listener.Dial()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: test\r\n\r\n"))
+	assert.NoError(t, err)
+	<-started
+
+	s.Shutdown()
+	assert.EqualError(t, <-stopped, "graceful shutdown timed out")
+
+	// the connection of the in-flight request is closed rather than left open
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Content type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// RFC 7807 problem details of an Error, https://tools.ietf.org/html/rfc7807
+type Problem struct {
+	// Uri identifying the problem type, "about:blank" when the status is enough
+	Type string
+	// Short summary of the problem type, the http status text
+	Title  string
+	Status int
+	// Explanation specific to this occurrence, the message of the error
+	Detail string
+	// Uri of this occurrence, ex: the request path
+	Instance string
+	// Additional members serialized next to the standard ones, ex: "invalidParams" or "requestId"
+	Extensions map[string]interface{}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	// the standard members win over extensions with the same name
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// Builds the problem details of error. Like ConvertToGrpcError, the message of 5xx errors is replaced by
+// SeriousErrorMessage so internal details never reach the client. Typed details become extension members
+func NewProblem(error *Error, instance string) *Problem {
+	status := error.StatusCode.HTTPStatus()
+	detail := error.Message
+	// compares the rendered status so unknown codes, rendered as 500, are hidden as well
+	if status >= int(StatusCode_InternalServerError) {
+		detail = SeriousErrorMessage
+	}
+
+	return &Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     detail,
+		Instance:   instance,
+		Extensions: getProblemExtensions(error),
+	}
+}
+
+func getProblemExtensions(error *Error) map[string]interface{} {
+	extensions := map[string]interface{}{}
+
+	if error.RetryInfo != nil {
+		extensions["retryDelaySeconds"] = error.RetryInfo.RetryDelay.Seconds()
+	}
+
+	if error.QuotaFailure != nil {
+		var violations []map[string]string
+		for _, v := range error.QuotaFailure.Violations {
+			violations = append(violations, map[string]string{"subject": v.Subject, "description": v.Description})
+		}
+		extensions["quotaViolations"] = violations
+	}
+
+	if error.ErrorInfo != nil {
+		extensions["errorInfo"] = map[string]interface{}{
+			"reason":   error.ErrorInfo.Reason,
+			"domain":   error.ErrorInfo.Domain,
+			"metadata": error.ErrorInfo.Metadata,
+		}
+	}
+
+	if error.PreconditionFailure != nil {
+		var violations []map[string]string
+		for _, v := range error.PreconditionFailure.Violations {
+			violations = append(violations, map[string]string{
+				"type":        v.Type,
+				"subject":     v.Subject,
+				"description": v.Description,
+			})
+		}
+		extensions["preconditionViolations"] = violations
+	}
+
+	// named after the example of the rfc
+	if error.BadRequest != nil {
+		var params []map[string]string
+		for _, v := range error.BadRequest.FieldViolations {
+			params = append(params, map[string]string{"name": v.Field, "reason": v.Description})
+		}
+		extensions["invalidParams"] = params
+	}
+
+	if error.LocalizedMessage != nil {
+		extensions["localizedMessage"] = map[string]string{
+			"locale":  error.LocalizedMessage.Locale,
+			"message": error.LocalizedMessage.Message,
+		}
+	}
+
+	return extensions
+}
+
+// Value of the Retry-After header of error, empty without RetryInfo
+func (e *Error) RetryAfter() string {
+	if e.RetryInfo == nil {
+		return ""
+	}
+
+	return strconv.Itoa(int(math.Ceil(e.RetryInfo.RetryDelay.Seconds())))
+}
+
+// Logs error with its status code, 5xx errors include the stack trace. Used by every server implementation
+// before sending an error
+func LogError(logger *zerolog.Logger, error *Error) {
+	event := logger.Error()
+	if error.StatusCode >= StatusCode_InternalServerError {
+		event = event.Stack()
+	}
+
+	event.
+		Err(error.Error).
+		Interface("statusCode", error.StatusCode).
+		Msg(error.Message)
+}
+
+// Logs error and writes it as problem details to a net/http response, with the request path as instance
+func WriteProblem(w http.ResponseWriter, r *http.Request, error *Error) {
+	logger := log.Ctx(r.Context())
+	// plain net/http requests carry no logger, zerolog then returns a disabled logger
+	if logger.GetLevel() == zerolog.Disabled {
+		logger = &log.Logger
+	}
+
+	LogError(logger, error)
+	WriteProblemResponse(w, r, error)
+}
+
+// Writes error as problem details without logging it, ex: when it was already logged by the grpc server
+func WriteProblemResponse(w http.ResponseWriter, r *http.Request, error *Error) {
+	body, _ := json.Marshal(NewProblem(error, r.URL.Path))
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	if retryAfter := error.RetryAfter(); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	w.WriteHeader(error.StatusCode.HTTPStatus())
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/kintohub/utils-go/server"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		name     string
+		error    *server.Error
+		expected string
+	}{
+		{
+			name:  "client error",
+			error: server.NewError(server.StatusCode_NotFound, "build 42 does not exist"),
+			expected: `{"type":"about:blank","title":"Not Found","status":404,"detail":"build 42 does not exist",
+				"instance":"/builds/42"}`,
+		},
+		{
+			name:  "internal message is hidden",
+			error: server.NewInternalErrorWithErr("mongo password is hunter2", errors.New("auth failed")),
+			expected: `{"type":"about:blank","title":"Internal Server Error","status":500,
+				"detail":"` + server.SeriousErrorMessage + `","instance":"/builds/42"}`,
+		},
+		{
+			name: "details as extensions",
+			error: server.NewError(server.StatusCode_BadRequest, "invalid build").
+				WithFieldViolation("name", "cannot be blank").
+				WithErrorInfo("INVALID_BUILD", "builds.kintohub.com", map[string]string{"id": "42"}),
+			expected: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid build",
+				"instance":"/builds/42","invalidParams":[{"name":"name","reason":"cannot be blank"}],
+				"errorInfo":{"reason":"INVALID_BUILD","domain":"builds.kintohub.com","metadata":{"id":"42"}}}`,
+		},
+		{
+			name:  "unknown status code",
+			error: server.NewError(server.StatusCode_Empty, "no status"),
+			expected: `{"type":"about:blank","title":"Internal Server Error","status":500,
+				"detail":"` + server.SeriousErrorMessage + `","instance":"/builds/42"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := json.Marshal(server.NewProblem(test.error, "/builds/42"))
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(body))
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/builds", nil)
+
+	server.WriteProblem(recorder, request,
+		server.NewError(server.StatusCode_TooManyRequests, "too many builds").WithRetryInfo(1500*time.Millisecond))
+
+	assert.Equal(t, 429, recorder.Code)
+	assert.Equal(t, server.ProblemContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"too many builds",
+		"instance":"/builds","retryDelaySeconds":1.5}`, recorder.Body.String())
+}
+
+func TestWriteProblem_LogsWithoutRequestLogger(t *testing.T) {
+	logs := &bytes.Buffer{}
+	globalLogger := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = globalLogger }()
+
+	// the request has no logger in its context, ex: the requests of the grpc gateway
+	request := httptest.NewRequest(http.MethodGet, "/builds", nil)
+	server.WriteProblem(httptest.NewRecorder(), request, server.NewInternalErrorWithErr("mongo is down", errors.New("timeout")))
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "mongo is down", entry["message"])
+	assert.Equal(t, "error", entry["level"])
+}
+
+func TestWriteProblemResponse_DoesNotLog(t *testing.T) {
+	logs := &bytes.Buffer{}
+	globalLogger := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = globalLogger }()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/builds", nil)
+	server.WriteProblemResponse(recorder, request, server.NewError(server.StatusCode_NotFound, "no such build"))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, server.ProblemContentType, recorder.Header().Get("Content-Type"))
+	assert.Empty(t, logs.String())
+}
@@ -0,0 +1,159 @@
+package server
+
+// Transport independent error code with the same values as google.rpc.Code, so grpc and any other rpc transport
+// can cast it directly to their own code type
+type RPCCode uint32
+
+const (
+	RPCCode_OK                 RPCCode = 0
+	RPCCode_Canceled           RPCCode = 1
+	RPCCode_Unknown            RPCCode = 2
+	RPCCode_InvalidArgument    RPCCode = 3
+	RPCCode_DeadlineExceeded   RPCCode = 4
+	RPCCode_NotFound           RPCCode = 5
+	RPCCode_AlreadyExists      RPCCode = 6
+	RPCCode_PermissionDenied   RPCCode = 7
+	RPCCode_ResourceExhausted  RPCCode = 8
+	RPCCode_FailedPrecondition RPCCode = 9
+	RPCCode_Aborted            RPCCode = 10
+	RPCCode_OutOfRange         RPCCode = 11
+	RPCCode_Unimplemented      RPCCode = 12
+	RPCCode_Internal           RPCCode = 13
+	RPCCode_Unavailable        RPCCode = 14
+	RPCCode_DataLoss           RPCCode = 15
+	RPCCode_Unauthenticated    RPCCode = 16
+)
+
+// Every StatusCode with its rpc counterpart. Non error codes (1xx, 2xx) are OK and redirections (3xx),
+// which have no rpc meaning, are Unknown
+var statusCodeToRPCCode = map[StatusCode]RPCCode{
+	StatusCode_Empty:                         RPCCode_Unknown,
+	StatusCode_Continue:                      RPCCode_OK,
+	StatusCode_OK:                            RPCCode_OK,
+	StatusCode_Created:                       RPCCode_OK,
+	StatusCode_Accepted:                      RPCCode_OK,
+	StatusCode_NonAuthoritativeInformation:   RPCCode_OK,
+	StatusCode_NoContent:                     RPCCode_OK,
+	StatusCode_ResetContent:                  RPCCode_OK,
+	StatusCode_PartialContent:                RPCCode_OK,
+	StatusCode_MultiStatus:                   RPCCode_OK,
+	StatusCode_AlreadyReported:               RPCCode_OK,
+	StatusCode_IMUsed:                        RPCCode_OK,
+	StatusCode_MultipleChoices:               RPCCode_Unknown,
+	StatusCode_MovedPermanently:              RPCCode_Unknown,
+	StatusCode_Found:                         RPCCode_Unknown,
+	StatusCode_SeeOther:                      RPCCode_Unknown,
+	StatusCode_NotModified:                   RPCCode_Unknown,
+	StatusCode_UseProxy:                      RPCCode_Unknown,
+	StatusCode_TemporaryRedirect:             RPCCode_Unknown,
+	StatusCode_PermanentRedirect:             RPCCode_Unknown,
+	StatusCode_BadRequest:                    RPCCode_InvalidArgument,
+	StatusCode_Unauthorized:                  RPCCode_Unauthenticated,
+	StatusCode_PaymentRequired:               RPCCode_FailedPrecondition,
+	StatusCode_Forbidden:                     RPCCode_PermissionDenied,
+	StatusCode_NotFound:                      RPCCode_NotFound,
+	StatusCode_MethodNotAllowed:              RPCCode_Unimplemented,
+	StatusCode_NotAcceptable:                 RPCCode_InvalidArgument,
+	StatusCode_ProxyAuthenticationRequired:   RPCCode_Unauthenticated,
+	StatusCode_RequestTimeout:                RPCCode_DeadlineExceeded,
+	StatusCode_Conflict:                      RPCCode_AlreadyExists,
+	StatusCode_Gone:                          RPCCode_NotFound,
+	StatusCode_LengthRequired:                RPCCode_InvalidArgument,
+	StatusCode_PreconditionFailed:            RPCCode_FailedPrecondition,
+	StatusCode_PayloadTooLarge:               RPCCode_ResourceExhausted,
+	StatusCode_URITooLong:                    RPCCode_InvalidArgument,
+	StatusCode_UnsupportedMediaType:          RPCCode_InvalidArgument,
+	StatusCode_RangeNotSatisfiable:           RPCCode_OutOfRange,
+	StatusCode_ExpectationFailed:             RPCCode_FailedPrecondition,
+	StatusCode_MisdirectedRequest:            RPCCode_Unavailable,
+	StatusCode_UnprocessableEntity:           RPCCode_InvalidArgument,
+	StatusCode_Locked:                        RPCCode_FailedPrecondition,
+	StatusCode_FailedDependency:              RPCCode_FailedPrecondition,
+	StatusCode_UpgradeRequired:               RPCCode_FailedPrecondition,
+	StatusCode_PreconditionRequired:          RPCCode_FailedPrecondition,
+	StatusCode_TooManyRequests:               RPCCode_ResourceExhausted,
+	StatusCode_RequestHeaderFieldsTooLarge:   RPCCode_ResourceExhausted,
+	StatusCode_ClientClosedRequest:           RPCCode_Canceled,
+	StatusCode_InternalServerError:           RPCCode_Internal,
+	StatusCode_NotImplemented:                RPCCode_Unimplemented,
+	StatusCode_BadGateway:                    RPCCode_Unavailable,
+	StatusCode_ServiceUnavailable:            RPCCode_Unavailable,
+	StatusCode_GatewayTimeout:                RPCCode_DeadlineExceeded,
+	StatusCode_HTTPVersionNotSupported:       RPCCode_Unimplemented,
+	StatusCode_VariantAlsoNegotiates:         RPCCode_Internal,
+	StatusCode_InsufficientStorage:           RPCCode_ResourceExhausted,
+	StatusCode_LoopDetected:                  RPCCode_Internal,
+	StatusCode_NotExtended:                   RPCCode_Internal,
+	StatusCode_NetworkAuthenticationRequired: RPCCode_Unauthenticated,
+}
+
+// Referenced from https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto except for
+// FailedPrecondition which uses 412 rather than 400 so PreconditionFailed survives a round trip
+var rpcCodeToStatusCode = map[RPCCode]StatusCode{
+	RPCCode_OK:                 StatusCode_OK,
+	RPCCode_Canceled:           StatusCode_ClientClosedRequest,
+	RPCCode_Unknown:            StatusCode_InternalServerError,
+	RPCCode_InvalidArgument:    StatusCode_BadRequest,
+	RPCCode_DeadlineExceeded:   StatusCode_GatewayTimeout,
+	RPCCode_NotFound:           StatusCode_NotFound,
+	RPCCode_AlreadyExists:      StatusCode_Conflict,
+	RPCCode_PermissionDenied:   StatusCode_Forbidden,
+	RPCCode_ResourceExhausted:  StatusCode_TooManyRequests,
+	RPCCode_FailedPrecondition: StatusCode_PreconditionFailed,
+	RPCCode_Aborted:            StatusCode_Conflict,
+	RPCCode_OutOfRange:         StatusCode_BadRequest,
+	RPCCode_Unimplemented:      StatusCode_NotImplemented,
+	RPCCode_Internal:           StatusCode_InternalServerError,
+	RPCCode_Unavailable:        StatusCode_ServiceUnavailable,
+	RPCCode_DataLoss:           StatusCode_InternalServerError,
+	RPCCode_Unauthenticated:    StatusCode_Unauthorized,
+}
+
+// Whether code is one of the StatusCode constants
+func (code StatusCode) IsValid() bool {
+	_, ok := statusCodeToRPCCode[code]
+	return ok && code != StatusCode_Empty
+}
+
+// Rpc code of code, Unknown for values that are not StatusCode constants
+func (code StatusCode) RPCCode() RPCCode {
+	if rpcCode, ok := statusCodeToRPCCode[code]; ok {
+		return rpcCode
+	}
+
+	return RPCCode_Unknown
+}
+
+// Http status of code. Values that are not StatusCode constants, including StatusCode_Empty, are 500
+func (code StatusCode) HTTPStatus() int {
+	if !code.IsValid() {
+		return int(StatusCode_InternalServerError)
+	}
+
+	return int(code)
+}
+
+func StatusCodeFromRPCCode(rpcCode RPCCode) StatusCode {
+	if code, ok := rpcCodeToStatusCode[rpcCode]; ok {
+		return code
+	}
+
+	return StatusCode_InternalServerError
+}
+
+// StatusCode of an http status, ex: from the response of another service. Unknown statuses use
+// the generic code of their class (200, 400 or 500)
+func StatusCodeFromHTTPStatus(httpStatus int) StatusCode {
+	code := StatusCode(httpStatus)
+
+	switch {
+	case code.IsValid():
+		return code
+	case httpStatus >= 200 && httpStatus < 300:
+		return StatusCode_OK
+	case httpStatus >= 400 && httpStatus < 500:
+		return StatusCode_BadRequest
+	default:
+		return StatusCode_InternalServerError
+	}
+}
@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"github.com/kintohub/utils-go/server"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStatusCode_RPCCode(t *testing.T) {
+	tests := []struct {
+		code     server.StatusCode
+		expected server.RPCCode
+	}{
+		{server.StatusCode_OK, server.RPCCode_OK},
+		{server.StatusCode_NoContent, server.RPCCode_OK},
+		{server.StatusCode_Found, server.RPCCode_Unknown},
+		{server.StatusCode_BadRequest, server.RPCCode_InvalidArgument},
+		{server.StatusCode_UnprocessableEntity, server.RPCCode_InvalidArgument},
+		{server.StatusCode_Unauthorized, server.RPCCode_Unauthenticated},
+		{server.StatusCode_Forbidden, server.RPCCode_PermissionDenied},
+		{server.StatusCode_NotFound, server.RPCCode_NotFound},
+		{server.StatusCode_Gone, server.RPCCode_NotFound},
+		{server.StatusCode_RequestTimeout, server.RPCCode_DeadlineExceeded},
+		{server.StatusCode_ClientClosedRequest, server.RPCCode_Canceled},
+		{server.StatusCode_Conflict, server.RPCCode_AlreadyExists},
+		{server.StatusCode_PreconditionFailed, server.RPCCode_FailedPrecondition},
+		{server.StatusCode_RangeNotSatisfiable, server.RPCCode_OutOfRange},
+		{server.StatusCode_TooManyRequests, server.RPCCode_ResourceExhausted},
+		{server.StatusCode_InternalServerError, server.RPCCode_Internal},
+		{server.StatusCode_NotImplemented, server.RPCCode_Unimplemented},
+		{server.StatusCode_BadGateway, server.RPCCode_Unavailable},
+		{server.StatusCode_ServiceUnavailable, server.RPCCode_Unavailable},
+		{server.StatusCode_GatewayTimeout, server.RPCCode_DeadlineExceeded},
+		{server.StatusCode_Empty, server.RPCCode_Unknown},
+		{server.StatusCode(999), server.RPCCode_Unknown},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.code.RPCCode(), "status code %d", test.code)
+	}
+}
+
+func TestStatusCodeFromRPCCode(t *testing.T) {
+	tests := []struct {
+		rpcCode  server.RPCCode
+		expected server.StatusCode
+	}{
+		{server.RPCCode_OK, server.StatusCode_OK},
+		{server.RPCCode_Canceled, server.StatusCode_ClientClosedRequest},
+		{server.RPCCode_Unknown, server.StatusCode_InternalServerError},
+		{server.RPCCode_InvalidArgument, server.StatusCode_BadRequest},
+		{server.RPCCode_DeadlineExceeded, server.StatusCode_GatewayTimeout},
+		{server.RPCCode_NotFound, server.StatusCode_NotFound},
+		{server.RPCCode_AlreadyExists, server.StatusCode_Conflict},
+		{server.RPCCode_PermissionDenied, server.StatusCode_Forbidden},
+		{server.RPCCode_ResourceExhausted, server.StatusCode_TooManyRequests},
+		{server.RPCCode_FailedPrecondition, server.StatusCode_PreconditionFailed},
+		{server.RPCCode_Aborted, server.StatusCode_Conflict},
+		{server.RPCCode_OutOfRange, server.StatusCode_BadRequest},
+		{server.RPCCode_Unimplemented, server.StatusCode_NotImplemented},
+		{server.RPCCode_Internal, server.StatusCode_InternalServerError},
+		{server.RPCCode_Unavailable, server.StatusCode_ServiceUnavailable},
+		{server.RPCCode_DataLoss, server.StatusCode_InternalServerError},
+		{server.RPCCode_Unauthenticated, server.StatusCode_Unauthorized},
+		{server.RPCCode(42), server.StatusCode_InternalServerError},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, server.StatusCodeFromRPCCode(test.rpcCode), "rpc code %d", test.rpcCode)
+	}
+}
+
+func TestStatusCodeFromRPCCode_RoundTrip(t *testing.T) {
+	// every rpc code maps back to itself except the ones sharing an http status with a more common code
+	lossy := map[server.RPCCode]bool{
+		server.RPCCode_Unknown:    true,
+		server.RPCCode_Aborted:    true,
+		server.RPCCode_OutOfRange: true,
+		server.RPCCode_DataLoss:   true,
+	}
+
+	for rpcCode := server.RPCCode_OK; rpcCode <= server.RPCCode_Unauthenticated; rpcCode++ {
+		if lossy[rpcCode] {
+			continue
+		}
+		assert.Equal(t, rpcCode, server.StatusCodeFromRPCCode(rpcCode).RPCCode(), "rpc code %d", rpcCode)
+	}
+}
+
+func TestStatusCode_HTTPStatus(t *testing.T) {
+	assert.Equal(t, 404, server.StatusCode_NotFound.HTTPStatus())
+	assert.Equal(t, 499, server.StatusCode_ClientClosedRequest.HTTPStatus())
+	assert.Equal(t, 500, server.StatusCode_Empty.HTTPStatus())
+	assert.Equal(t, 500, server.StatusCode(999).HTTPStatus())
+}
+
+func TestStatusCodeFromHTTPStatus(t *testing.T) {
+	tests := []struct {
+		httpStatus int
+		expected   server.StatusCode
+	}{
+		{200, server.StatusCode_OK},
+		{299, server.StatusCode_OK},
+		{404, server.StatusCode_NotFound},
+		{418, server.StatusCode_BadRequest},
+		{503, server.StatusCode_ServiceUnavailable},
+		{599, server.StatusCode_InternalServerError},
+		{0, server.StatusCode_InternalServerError},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, server.StatusCodeFromHTTPStatus(test.httpStatus), "http status %d", test.httpStatus)
+	}
+}
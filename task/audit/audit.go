@@ -0,0 +1,62 @@
+// Package audit keeps a timeline of task lifecycle events that can be used as a proof of when a task ran and how it ended
+package audit
+
+import (
+	"github.com/kintohub/utils-go/klog"
+	"github.com/kintohub/utils-go/task"
+	"time"
+)
+
+// A single entry of the audit timeline
+type Record struct {
+	Time        time.Time      `json:"time"`
+	EventType   task.EventType `json:"eventType"`
+	TaskName    string         `json:"taskName"`
+	TaskId      string         `json:"taskId,omitempty"`
+	RequestId   string         `json:"requestId,omitempty"`
+	WorkerAlias string         `json:"workerAlias,omitempty"`
+	DurationMs  int64          `json:"durationMs,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// Persists audit records, ex: LogWriter, FileWriter or SQLWriter
+type Writer interface {
+	Write(record *Record) error
+}
+
+// Sink turning task events into audit records. Register HandleEvent as an event listener
+// of the task client, ex: MachineryConfig.EventListeners
+type Auditor struct {
+	writer Writer
+}
+
+func NewAuditor(writer Writer) *Auditor {
+	return &Auditor{writer: writer}
+}
+
+// Implements task.EventListener. Auditing never fails a task, write errors are only logged
+func (a *Auditor) HandleEvent(event *task.Event) {
+	record := NewRecord(event)
+
+	if err := a.writer.Write(record); err != nil {
+		klog.ErrorfWithErr(err, "could not write audit record of %s task %s", record.EventType, record.TaskId)
+	}
+}
+
+func NewRecord(event *task.Event) *Record {
+	record := &Record{
+		Time:        event.Time.UTC(),
+		EventType:   event.Type,
+		TaskName:    event.TaskName,
+		TaskId:      event.TaskId,
+		RequestId:   event.RequestId,
+		WorkerAlias: event.WorkerAlias,
+		DurationMs:  event.Duration.Milliseconds(),
+	}
+
+	if event.Error != nil {
+		record.Error = event.Error.Error()
+	}
+
+	return record
+}
@@ -0,0 +1,176 @@
+package audit_test
+
+import (
+	"bufio"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/kintohub/utils-go/task"
+	"github.com/kintohub/utils-go/task/audit"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditor_FileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	writer, err := audit.NewFileWriter(path)
+	assert.NoError(t, err)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	auditor := audit.NewAuditor(writer)
+	auditor.HandleEvent(&task.Event{
+		Type:        task.EventType_Started,
+		TaskName:    "helloworld",
+		TaskId:      "task_1",
+		RequestId:   "req_1",
+		WorkerAlias: "example-tasks-workers",
+		Time:        now,
+	})
+	auditor.HandleEvent(&task.Event{
+		Type:        task.EventType_Retried,
+		TaskName:    "helloworld",
+		TaskId:      "task_1",
+		RequestId:   "req_1",
+		WorkerAlias: "example-tasks-workers",
+		Time:        now.Add(time.Second),
+		Duration:    1500 * time.Millisecond,
+		Error:       errors.New("boom"),
+	})
+	assert.NoError(t, writer.Close())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var records []*audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := new(audit.Record)
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+		records = append(records, record)
+	}
+
+	assert.Equal(t, []*audit.Record{
+		{
+			Time:        now,
+			EventType:   task.EventType_Started,
+			TaskName:    "helloworld",
+			TaskId:      "task_1",
+			RequestId:   "req_1",
+			WorkerAlias: "example-tasks-workers",
+		},
+		{
+			Time:        now.Add(time.Second),
+			EventType:   task.EventType_Retried,
+			TaskName:    "helloworld",
+			TaskId:      "task_1",
+			RequestId:   "req_1",
+			WorkerAlias: "example-tasks-workers",
+			DurationMs:  1500,
+			Error:       "boom",
+		},
+	}, records)
+}
+
+// Minimal sql driver recording the executed statements
+type recordingDriver struct {
+	mutex   sync.Mutex
+	queries []string
+	args    [][]driver.Value
+	execErr error
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{driver: c.driver, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type recordingStmt struct {
+	driver *recordingDriver
+	query  string
+}
+
+func (s *recordingStmt) Close() error {
+	return nil
+}
+
+func (s *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.mutex.Lock()
+	defer s.driver.mutex.Unlock()
+
+	if s.driver.execErr != nil {
+		return nil, s.driver.execErr
+	}
+
+	s.driver.queries = append(s.driver.queries, s.query)
+	s.driver.args = append(s.driver.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func TestAuditor_SQLWriter(t *testing.T) {
+	recordingDriver := &recordingDriver{}
+	sql.Register("audit_recording", recordingDriver)
+
+	db, err := sql.Open("audit_recording", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	insertQuery := "INSERT INTO task_audit (time, event_type, task_name, task_id, request_id, worker_alias, " +
+		"duration_ms, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	writer := audit.NewSQLWriter(db, insertQuery)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	auditor := audit.NewAuditor(writer)
+	auditor.HandleEvent(&task.Event{
+		Type:        task.EventType_DeadLettered,
+		TaskName:    "helloworld",
+		TaskId:      "task_1",
+		RequestId:   "req_1",
+		WorkerAlias: "example-tasks-workers",
+		Time:        now,
+		Duration:    250 * time.Millisecond,
+		Error:       errors.New("boom"),
+	})
+
+	assert.Equal(t, []string{insertQuery}, recordingDriver.queries)
+	assert.Equal(t, [][]driver.Value{
+		{now, "dead-lettered", "helloworld", "task_1", "req_1", "example-tasks-workers", int64(250), "boom"},
+	}, recordingDriver.args)
+
+	// write errors are returned to the auditor, which only logs them
+	recordingDriver.execErr = errors.New("database is down")
+	assert.EqualError(t, writer.Write(audit.NewRecord(&task.Event{Type: task.EventType_Started})), "database is down")
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"os"
+	"sync"
+)
+
+// Writes audit records as info logs
+type LogWriter struct {
+	logger zerolog.Logger
+}
+
+// ex: NewLogWriter(klog.GetLogger())
+func NewLogWriter(logger zerolog.Logger) *LogWriter {
+	return &LogWriter{logger: logger}
+}
+
+func (w *LogWriter) Write(record *Record) error {
+	w.logger.Info().
+		Time("eventTime", record.Time).
+		Str("eventType", string(record.EventType)).
+		Str("taskName", record.TaskName).
+		Str("taskId", record.TaskId).
+		Str("requestId", record.RequestId).
+		Str("workerAlias", record.WorkerAlias).
+		Int64("durationMs", record.DurationMs).
+		Str("error", record.Error).
+		Msg("task audit")
+
+	return nil
+}
+
+// Appends audit records to a file as json lines
+type FileWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Opens path for appending, creating it if needed. Close must be called when done
+func NewFileWriter(path string) (*FileWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileWriter{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+func (w *FileWriter) Write(record *Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.encoder.Encode(record)
+}
+
+func (w *FileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Close()
+}
+
+// Inserts audit records with a user provided statement so any sql driver and placeholder style can be used.
+// The statement receives, in order: time, event type, task name, task id, request id, worker alias, duration ms, error.
+// ex (postgres): INSERT INTO task_audit (time, event_type, task_name, task_id, request_id, worker_alias, duration_ms, error)
+// VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+type SQLWriter struct {
+	db          *sql.DB
+	insertQuery string
+}
+
+func NewSQLWriter(db *sql.DB, insertQuery string) *SQLWriter {
+	return &SQLWriter{
+		db:          db,
+		insertQuery: insertQuery,
+	}
+}
+
+func (w *SQLWriter) Write(record *Record) error {
+	_, err := w.db.Exec(w.insertQuery,
+		record.Time,
+		string(record.EventType),
+		record.TaskName,
+		record.TaskId,
+		record.RequestId,
+		record.WorkerAlias,
+		record.DurationMs,
+		record.Error,
+	)
+
+	return err
+}
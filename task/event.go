@@ -0,0 +1,40 @@
+package task
+
+import "time"
+
+type EventType string
+
+const (
+	// The task was sent to the broker
+	EventType_Submitted EventType = "submitted"
+	// A worker picked up the task and is about to call its handler
+	EventType_Started EventType = "started"
+	// The handler returned an error and the task was sent back to the queue
+	EventType_Retried EventType = "retried"
+	// The handler returned without an error
+	EventType_Succeeded EventType = "succeeded"
+	// The handler returned an error and the task will not be retried
+	EventType_Failed EventType = "failed"
+	// The failed task was moved to the dead letter queue. Always follows a failed event
+	EventType_DeadLettered EventType = "dead-lettered"
+)
+
+// A lifecycle event of a single task
+type Event struct {
+	Type     EventType
+	TaskName string
+	// Unique id of the task assigned on submission, shared by all the attempts of a task
+	TaskId    string
+	RequestId string
+	// Alias of the worker, or of the submitting client for submitted events
+	WorkerAlias string
+	Time        time.Time
+	// Time spent inside the handler. Only set for retried, succeeded and failed events
+	Duration time.Duration
+	// Error returned by the handler. Only set for retried, failed and dead-lettered events
+	Error error
+}
+
+// Receives lifecycle events of tasks. Listeners are called synchronously by the workers
+// so they must be safe for concurrent use and should return quickly
+type EventListener func(event *Event)
@@ -0,0 +1,75 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Snapshot of the state of a task client, used for liveness and readiness probes
+type HealthStatus struct {
+	BrokerReachable bool   `json:"brokerReachable"`
+	BrokerError     string `json:"brokerError,omitempty"`
+	WorkersEnabled  bool   `json:"workersEnabled"`
+	// Whether the worker is still launched and consuming tasks from the broker
+	WorkerConsuming bool   `json:"workerConsuming"`
+	WorkerError     string `json:"workerError,omitempty"`
+	// Zero when no task succeeded since the client was created
+	LastSuccessfulTaskTime time.Time `json:"lastSuccessfulTaskTime"`
+}
+
+// A client is healthy when its broker is reachable and, if workers are enabled, they are still consuming
+func (h *HealthStatus) IsHealthy() bool {
+	return h.BrokerReachable && (!h.WorkersEnabled || h.WorkerConsuming)
+}
+
+var errHealthNotSupported = errors.New("the task client does not implement task.HealthChecker")
+
+// Serves the health of client as json with a 200 status code when healthy or a 503 otherwise.
+// Can be mounted as a kubernetes liveness or readiness probe. Answers 501 when client is not a HealthChecker
+func HealthHandler(client TaskClientInterface) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		checker, ok := client.(HealthChecker)
+		if !ok {
+			http.Error(resp, errHealthNotSupported.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		status := checker.Health(req.Context())
+
+		resp.Header().Set("Content-Type", "application/json")
+
+		if status.IsHealthy() {
+			resp.WriteHeader(http.StatusOK)
+		} else {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(resp).Encode(status)
+	})
+}
+
+// Returns a health check of client that fails when the client is unhealthy, or is not a HealthChecker.
+// ex: grpc.WithHealthChecker("tasks", task.CheckHealth(client))
+func CheckHealth(client TaskClientInterface) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		checker, ok := client.(HealthChecker)
+		if !ok {
+			return errHealthNotSupported
+		}
+
+		status := checker.Health(ctx)
+
+		if status.IsHealthy() {
+			return nil
+		}
+
+		if status.BrokerError != "" {
+			return errors.New("task broker is unreachable: " + status.BrokerError)
+		}
+
+		return errors.New("task worker stopped consuming: " + status.WorkerError)
+	}
+}
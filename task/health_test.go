@@ -0,0 +1,114 @@
+package task_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kintohub/utils-go/task"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Reports a fixed health status
+type healthCheckingClient struct {
+	task.TaskClientInterface
+	status *task.HealthStatus
+}
+
+func (c *healthCheckingClient) Health(ctx context.Context) *task.HealthStatus {
+	return c.status
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		client         task.TaskClientInterface
+		expectedStatus int
+	}{
+		{
+			name: "healthy",
+			client: &healthCheckingClient{status: &task.HealthStatus{
+				BrokerReachable: true,
+				WorkersEnabled:  true,
+				WorkerConsuming: true,
+			}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "worker stopped",
+			client: &healthCheckingClient{status: &task.HealthStatus{
+				BrokerReachable: true,
+				WorkersEnabled:  true,
+				WorkerError:     "connection reset",
+			}},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "health not supported",
+			client:         struct{ task.TaskClientInterface }{},
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			task.HealthHandler(test.client).ServeHTTP(resp, httptest.NewRequest("GET", "/healthz", nil))
+
+			assert.Equal(t, test.expectedStatus, resp.Code)
+
+			if checker, ok := test.client.(*healthCheckingClient); ok {
+				status := &task.HealthStatus{}
+				assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), status))
+				assert.Equal(t, checker.status, status)
+			}
+		})
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	tests := []struct {
+		name          string
+		client        task.TaskClientInterface
+		expectedError string
+	}{
+		{
+			name:   "healthy without workers",
+			client: &healthCheckingClient{status: &task.HealthStatus{BrokerReachable: true}},
+		},
+		{
+			name: "broker unreachable",
+			client: &healthCheckingClient{status: &task.HealthStatus{
+				BrokerError: "connection refused",
+			}},
+			expectedError: "task broker is unreachable: connection refused",
+		},
+		{
+			name: "worker stopped",
+			client: &healthCheckingClient{status: &task.HealthStatus{
+				BrokerReachable: true,
+				WorkersEnabled:  true,
+				WorkerError:     "connection reset",
+			}},
+			expectedError: "task worker stopped consuming: connection reset",
+		},
+		{
+			name:          "health not supported",
+			client:        struct{ task.TaskClientInterface }{},
+			expectedError: "the task client does not implement task.HealthChecker",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := task.CheckHealth(test.client)(context.Background())
+
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedError)
+			}
+		})
+	}
+}
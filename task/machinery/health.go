@@ -0,0 +1,96 @@
+package machinery
+
+import (
+	"context"
+	"fmt"
+	"github.com/kintohub/utils-go/task"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Used when the context of the health check has no earlier deadline
+const brokerPingTimeout = 5 * time.Second
+
+var defaultBrokerPorts = map[string]string{
+	"redis": "6379",
+	"amqp":  "5672",
+	"amqps": "5671",
+}
+
+func (m *MachineryTaskClient) Health(ctx context.Context) *task.HealthStatus {
+	m.healthMutex.RLock()
+	status := &task.HealthStatus{
+		WorkersEnabled:         m.config.WorkersEnabled,
+		WorkerConsuming:        m.workerConsuming,
+		LastSuccessfulTaskTime: m.lastSuccessfulTaskTime,
+	}
+	if m.workerError != nil {
+		status.WorkerError = m.workerError.Error()
+	}
+	m.healthMutex.RUnlock()
+
+	if err := pingBroker(ctx, m.config.BrokerConnectionUri); err != nil {
+		status.BrokerError = err.Error()
+	} else {
+		status.BrokerReachable = true
+	}
+
+	return status
+}
+
+// Opens and closes a connection to the broker. Only tcp brokers (redis, amqp) and redis sockets are checked,
+// other brokers (sqs, gcp pubsub) are managed services and are assumed to be reachable
+func pingBroker(ctx context.Context, brokerUri string) error {
+	network, address, err := getBrokerAddress(brokerUri)
+
+	if err != nil {
+		return err
+	}
+
+	if address == "" {
+		return nil
+	}
+
+	conn, err := (&net.Dialer{Timeout: brokerPingTimeout}).DialContext(ctx, network, address)
+
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Returns an empty address when the broker cannot be checked
+func getBrokerAddress(brokerUri string) (network, address string, err error) {
+	// redis+socket://password@/path/to/file.sock:/db
+	if strings.HasPrefix(brokerUri, "redis+socket://") {
+		path := strings.TrimPrefix(brokerUri, "redis+socket://")
+		if i := strings.Index(path, "@"); i != -1 {
+			path = path[i+1:]
+		}
+		return "unix", strings.SplitN(path, ":", 2)[0], nil
+	}
+
+	u, err := url.Parse(brokerUri)
+
+	if err != nil {
+		return "", "", fmt.Errorf("invalid broker uri: %v", err)
+	}
+
+	defaultPort, ok := defaultBrokerPorts[u.Scheme]
+
+	if !ok {
+		return "", "", nil
+	}
+
+	// redis cluster uris have comma separated hosts, checking the first one is enough
+	host := strings.Split(u.Host, ",")[0]
+
+	if u.Port() == "" && !strings.Contains(host, ":") {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+
+	return "tcp", host, nil
+}
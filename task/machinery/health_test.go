@@ -0,0 +1,80 @@
+package machinery_test
+
+import (
+	"context"
+	"github.com/kintohub/utils-go/task"
+	"github.com/kintohub/utils-go/task/machinery"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMachineryTaskClient_Health(t *testing.T) {
+	tcpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcpLis.Close()
+
+	dir, err := ioutil.TempDir("", "broker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "redis.sock")
+	socketLis, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	defer socketLis.Close()
+
+	closedLis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedAddress := closedLis.Addr().String()
+	closedLis.Close()
+
+	tests := []struct {
+		name              string
+		brokerUri         string
+		expectedReachable bool
+	}{
+		{
+			name:              "redis",
+			brokerUri:         "redis://password@" + tcpLis.Addr().String(),
+			expectedReachable: true,
+		},
+		{
+			name:              "redis socket",
+			brokerUri:         "redis+socket://password@" + socketPath + ":/0",
+			expectedReachable: true,
+		},
+		{
+			name:              "amqp",
+			brokerUri:         "amqp://guest:guest@" + tcpLis.Addr().String() + "/",
+			expectedReachable: true,
+		},
+		{
+			name:      "unreachable broker",
+			brokerUri: "redis://" + closedAddress,
+		},
+		{
+			name:              "brokers that are not checked",
+			brokerUri:         "eager",
+			expectedReachable: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := machinery.NewMachineryTaskClient(&machinery.MachineryConfig{
+				BrokerConnectionUri: test.brokerUri,
+				DefaultQueueName:    "tasks",
+			})
+
+			status := client.(task.HealthChecker).Health(context.Background())
+
+			assert.Equal(t, test.expectedReachable, status.BrokerReachable, status.BrokerError)
+			assert.Equal(t, !test.expectedReachable, status.BrokerError != "")
+			assert.False(t, status.WorkersEnabled)
+			assert.True(t, status.IsHealthy() == test.expectedReachable)
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package machinery
 
 import (
+	"context"
+	"fmt"
 	"github.com/RichardKnop/machinery/v1"
 	machineryConfig "github.com/RichardKnop/machinery/v1/config"
 	"github.com/RichardKnop/machinery/v1/tasks"
 	"github.com/kintohub/utils-go/klog"
 	"github.com/kintohub/utils-go/task"
 	"math"
+	"sync"
+	"time"
 )
 
 type MachineryConfig struct {
@@ -19,11 +23,21 @@ type MachineryConfig struct {
 	WorkerConcurrencyLimit     int // 0 == no limit
 	MaxRetryCount              int // When set to -1
 	RetryTimeoutSeconds        int
+	EventListeners             []task.EventListener // optional, ex: metrics.TaskMetrics.HandleEvent
+	DeadLetterQueueName        string               // optional, failed tasks are moved to this queue when set, once
 }
 
+// Signature header carrying task.Task.RequestId
+const requestIdHeader = "requestId"
+
 type MachineryTaskClient struct {
 	server *machinery.Server
 	config *MachineryConfig
+
+	healthMutex            sync.RWMutex
+	workerConsuming        bool
+	workerError            error
+	lastSuccessfulTaskTime time.Time
 }
 
 func NewMachineryTaskClient(config *MachineryConfig) task.TaskClientInterface {
@@ -44,54 +58,208 @@ func NewMachineryTaskClient(config *MachineryConfig) task.TaskClientInterface {
 		klog.PanicfWithError(err, "could not start machinery server")
 	}
 
+	client := &MachineryTaskClient{
+		server: server,
+		config: config,
+	}
+
 	if config.WorkersEnabled {
 		worker := server.NewWorker(
 			config.WorkerAlias,
 			config.WorkerConcurrencyLimit,
 		)
+		client.workerConsuming = true
+
 		go func() {
 			// Blocking func
 			err := worker.Launch()
-			if err != nil {
-				klog.PanicfWithError(err, "could not start worker(s)")
+
+			// the worker is gone for good, report it through Health instead of crashing the app
+			client.healthMutex.Lock()
+			client.workerConsuming = false
+			client.workerError = err
+			client.healthMutex.Unlock()
+
+			if err == machinery.ErrWorkerQuitGracefully {
+				klog.Warn("machinery worker(s) quit gracefully")
+			} else {
+				klog.ErrorWithErr(err, "machinery worker(s) stopped consuming tasks")
 			}
 		}()
 	}
 
-	return &MachineryTaskClient{
-		server: server,
-		config: config,
-	}
+	return client
 }
 
-func (m *MachineryTaskClient) SubmitTask(task *task.Task) error {
-	_, err := m.server.SendTask(&tasks.Signature{
-		Name: task.Name,
+func (m *MachineryTaskClient) SubmitTask(t *task.Task) error {
+	signature := &tasks.Signature{
+		Name: t.Name,
 		Args: []tasks.Arg{
 			{
 				Type:  "string",
-				Value: task.Data,
+				Value: t.Data,
 			},
 		},
 		RetryCount:   m.config.MaxRetryCount,
 		RetryTimeout: m.config.RetryTimeoutSeconds, // 0 == fib sequence
+	}
+
+	if t.RequestId != "" {
+		signature.Headers = tasks.Headers{requestIdHeader: t.RequestId}
+	}
+
+	_, err := m.server.SendTask(signature)
+
+	if err != nil {
+		return err
+	}
+
+	// machinery assigns the uuid of the signature when sending it
+	m.emitEvent(&task.Event{
+		Type:        task.EventType_Submitted,
+		TaskName:    t.Name,
+		TaskId:      signature.UUID,
+		RequestId:   t.RequestId,
+		WorkerAlias: m.config.WorkerAlias,
+		Time:        time.Now(),
 	})
 
-	return err
+	return nil
 }
 
 func (m *MachineryTaskClient) RegisterTaskHandler(taskName string, taskHandler task.TaskHandler) error {
-	return m.server.RegisterTask(taskName, taskHandler)
+	return m.server.RegisterTask(taskName, m.wrapTaskHandler(taskName, func(requestId, json string) error {
+		return taskHandler(json)
+	}))
 }
 
 func (m *MachineryTaskClient) RegisterChainTaskHandler(taskName string, chainTaskHandler task.ChainTaskHandler) error {
-	return m.server.RegisterTask(taskName, func(json string) error {
+	return m.server.RegisterTask(taskName, m.wrapTaskHandler(taskName, func(requestId, json string) error {
 		nextTask, err := chainTaskHandler(json)
 
 		if err != nil {
 			return err
 		}
 
+		// nothing to follow up with
+		if nextTask == nil {
+			return nil
+		}
+
+		if nextTask.RequestId == "" {
+			nextTask.RequestId = requestId
+		}
+
 		return m.SubmitTask(nextTask)
+	}))
+}
+
+// Wraps the handler so that lifecycle events are sent to the configured listeners.
+// Machinery injects the task signature into the context when the first argument of the handler is a context
+func (m *MachineryTaskClient) wrapTaskHandler(
+	taskName string, taskHandler func(requestId, json string) error) interface{} {
+	return func(ctx context.Context, json string) (err error) {
+		newEvent := func(eventType task.EventType) *task.Event {
+			event := &task.Event{
+				Type:        eventType,
+				TaskName:    taskName,
+				WorkerAlias: m.config.WorkerAlias,
+				Time:        time.Now(),
+			}
+
+			if signature := tasks.SignatureFromContext(ctx); signature != nil {
+				event.TaskId = signature.UUID
+				event.RequestId, _ = signature.Headers[requestIdHeader].(string)
+			}
+
+			return event
+		}
+
+		startEvent := newEvent(task.EventType_Started)
+		m.emitEvent(startEvent)
+
+		defer func() {
+			// machinery recovers panics and handles them as regular errors, so do the same
+			r := recover()
+			if r != nil {
+				err = fmt.Errorf("task handler panicked: %v", r)
+			}
+
+			completedEvent := newEvent(getCompletedEventType(ctx, err))
+			completedEvent.Duration = completedEvent.Time.Sub(startEvent.Time)
+			completedEvent.Error = err
+			m.emitEvent(completedEvent)
+
+			if completedEvent.Type == task.EventType_Failed && m.config.DeadLetterQueueName != "" &&
+				!m.isDeadLetterCopy(ctx) {
+				m.deadLetterTask(ctx, newEvent(task.EventType_DeadLettered), err)
+			}
+
+			if r != nil {
+				panic(r)
+			}
+		}()
+
+		return taskHandler(startEvent.RequestId, json)
+	}
+}
+
+// Sends a copy of the failed task without retries to the dead letter queue so it can be inspected or replayed
+func (m *MachineryTaskClient) deadLetterTask(ctx context.Context, event *task.Event, taskErr error) {
+	signature := tasks.SignatureFromContext(ctx)
+
+	if signature == nil {
+		return
+	}
+
+	_, err := m.server.SendTask(&tasks.Signature{
+		UUID:       signature.UUID,
+		Name:       signature.Name,
+		RoutingKey: m.config.DeadLetterQueueName,
+		Args:       signature.Args,
+		Headers:    signature.Headers,
 	})
+
+	if err != nil {
+		klog.ErrorfWithErr(err, "could not move task %s to the dead letter queue", signature.UUID)
+		return
+	}
+
+	event.Error = taskErr
+	m.emitEvent(event)
+}
+
+// The copies consumed from the dead letter queue are not dead lettered again when they fail, they would loop forever
+func (m *MachineryTaskClient) isDeadLetterCopy(ctx context.Context) bool {
+	signature := tasks.SignatureFromContext(ctx)
+	return signature != nil && signature.RoutingKey == m.config.DeadLetterQueueName
+}
+
+func (m *MachineryTaskClient) emitEvent(event *task.Event) {
+	if event.Type == task.EventType_Succeeded {
+		m.healthMutex.Lock()
+		m.lastSuccessfulTaskTime = event.Time
+		m.healthMutex.Unlock()
+	}
+
+	for _, listener := range m.config.EventListeners {
+		listener(event)
+	}
+}
+
+// Mirrors the retry decision taken by the machinery worker once the handler returns
+func getCompletedEventType(ctx context.Context, err error) task.EventType {
+	if err == nil {
+		return task.EventType_Succeeded
+	}
+
+	if _, ok := err.(tasks.ErrRetryTaskLater); ok {
+		return task.EventType_Retried
+	}
+
+	if signature := tasks.SignatureFromContext(ctx); signature != nil && signature.RetryCount > 0 {
+		return task.EventType_Retried
+	}
+
+	return task.EventType_Failed
 }
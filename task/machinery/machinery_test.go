@@ -0,0 +1,136 @@
+package machinery_test
+
+import (
+	"errors"
+	"github.com/kintohub/utils-go/task"
+	"github.com/kintohub/utils-go/task/machinery"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// Records the events of a client
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events []*task.Event
+}
+
+func (r *eventRecorder) handleEvent(event *task.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) getEventTypes() []task.EventType {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var types []task.EventType
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+
+	return types
+}
+
+// The eager broker runs the tasks synchronously when they are submitted
+func newEagerClient(recorder *eventRecorder, deadLetterQueueName string) task.TaskClientInterface {
+	return machinery.NewMachineryTaskClient(&machinery.MachineryConfig{
+		BrokerConnectionUri:        "eager",
+		ResultBackendConnectionUri: "eager",
+		DefaultQueueName:           "tasks",
+		WorkerAlias:                "test-worker",
+		EventListeners:             []task.EventListener{recorder.handleEvent},
+		DeadLetterQueueName:        deadLetterQueueName,
+	})
+}
+
+func TestMachineryTaskClient_DeadLetterQueue(t *testing.T) {
+	recorder := &eventRecorder{}
+	client := newEagerClient(recorder, "tasks_dead_letter")
+
+	taskErr := errors.New("boom")
+	var calls int
+	// the eager broker runs the dead lettered copy right away, let it succeed
+	assert.NoError(t, client.RegisterTaskHandler("billing", func(json string) error {
+		calls++
+		if calls == 1 {
+			return taskErr
+		}
+		return nil
+	}))
+
+	_ = client.SubmitTask(&task.Task{Name: "billing", Data: `{"invoice":1}`, RequestId: "req_1"})
+
+	assert.Equal(t, []task.EventType{
+		task.EventType_Started,
+		task.EventType_Failed,
+		// the copy sent to the dead letter queue
+		task.EventType_Started,
+		task.EventType_Succeeded,
+		task.EventType_DeadLettered,
+		task.EventType_Submitted,
+	}, recorder.getEventTypes())
+
+	failed, deadLetterCopy, deadLettered := recorder.events[1], recorder.events[2], recorder.events[4]
+	assert.NotEmpty(t, failed.TaskId)
+	assert.Equal(t, failed.TaskId, deadLetterCopy.TaskId)
+	assert.Equal(t, "req_1", deadLetterCopy.RequestId)
+	assert.Equal(t, failed.TaskId, deadLettered.TaskId)
+	assert.Equal(t, "req_1", deadLettered.RequestId)
+	assert.Equal(t, taskErr, deadLettered.Error)
+}
+
+func TestMachineryTaskClient_DeadLetterQueueFailingCopy(t *testing.T) {
+	recorder := &eventRecorder{}
+	client := newEagerClient(recorder, "tasks_dead_letter")
+
+	var calls int
+	assert.NoError(t, client.RegisterTaskHandler("billing", func(json string) error {
+		calls++
+		return errors.New("boom")
+	}))
+
+	_ = client.SubmitTask(&task.Task{Name: "billing", Data: `{"invoice":1}`})
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []task.EventType{
+		task.EventType_Started,
+		task.EventType_Failed,
+		// the copy sent to the dead letter queue fails too and stays there
+		task.EventType_Started,
+		task.EventType_Failed,
+		task.EventType_DeadLettered,
+		task.EventType_Submitted,
+	}, recorder.getEventTypes())
+}
+
+func TestMachineryTaskClient_NoDeadLetterQueue(t *testing.T) {
+	recorder := &eventRecorder{}
+	client := newEagerClient(recorder, "")
+
+	assert.NoError(t, client.RegisterTaskHandler("billing", func(json string) error {
+		return errors.New("boom")
+	}))
+
+	_ = client.SubmitTask(&task.Task{Name: "billing", Data: `{}`})
+
+	assert.Equal(t, []task.EventType{
+		task.EventType_Started, task.EventType_Failed, task.EventType_Submitted,
+	}, recorder.getEventTypes())
+}
+
+func TestMachineryTaskClient_ChainTaskWithoutFollowUp(t *testing.T) {
+	recorder := &eventRecorder{}
+	client := newEagerClient(recorder, "")
+
+	assert.NoError(t, client.RegisterChainTaskHandler("billing", func(json string) (*task.Task, error) {
+		return nil, nil
+	}))
+
+	assert.NoError(t, client.SubmitTask(&task.Task{Name: "billing", Data: `{}`}))
+
+	assert.Equal(t, []task.EventType{
+		task.EventType_Started, task.EventType_Succeeded, task.EventType_Submitted,
+	}, recorder.getEventTypes())
+}
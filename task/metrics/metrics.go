@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"github.com/kintohub/utils-go/task"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const taskNameLabel = "task"
+
+// Prometheus collectors for task workers. Pass HandleEvent as an event listener
+// of the task client to start collecting, ex: MachineryConfig.EventListeners
+type TaskMetrics struct {
+	submitted  *prometheus.CounterVec
+	started    *prometheus.CounterVec
+	succeeded  *prometheus.CounterVec
+	failed     *prometheus.CounterVec
+	retried    *prometheus.CounterVec
+	deadLetter *prometheus.CounterVec
+	inProgress *prometheus.GaugeVec
+	duration   *prometheus.HistogramVec
+}
+
+// Creates the task collectors and registers them with registerer. namespace is prepended to
+// every metric name and can be left empty. Use prometheus.DefaultRegisterer to expose
+// the metrics alongside the go runtime metrics
+func NewTaskMetrics(namespace string, registerer prometheus.Registerer) (*TaskMetrics, error) {
+	newCounter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "task",
+			Name:      name,
+			Help:      help,
+		}, []string{taskNameLabel})
+	}
+
+	m := &TaskMetrics{
+		submitted: newCounter("submitted_total", "Total number of tasks sent to the broker."),
+		started:   newCounter("started_total", "Total number of task executions started by the workers."),
+		succeeded: newCounter("succeeded_total", "Total number of task executions that succeeded."),
+		failed:    newCounter("failed_total", "Total number of tasks that failed and will not be retried."),
+		retried:   newCounter("retried_total", "Total number of task executions that failed and were retried."),
+		deadLetter: newCounter("dead_lettered_total",
+			"Total number of failed tasks moved to the dead letter queue."),
+		inProgress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "task",
+			Name:      "in_progress",
+			Help:      "Number of tasks currently being processed by the workers.",
+		}, []string{taskNameLabel}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "task",
+			Name:      "duration_seconds",
+			Help:      "Time spent by the workers processing a task, by result.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{taskNameLabel, "result"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.submitted, m.started, m.succeeded, m.failed, m.retried, m.deadLetter, m.inProgress, m.duration,
+	}
+
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Implements task.EventListener
+func (m *TaskMetrics) HandleEvent(event *task.Event) {
+	switch event.Type {
+	case task.EventType_Submitted:
+		m.submitted.WithLabelValues(event.TaskName).Inc()
+	case task.EventType_Started:
+		m.started.WithLabelValues(event.TaskName).Inc()
+		m.inProgress.WithLabelValues(event.TaskName).Inc()
+	case task.EventType_Succeeded:
+		m.succeeded.WithLabelValues(event.TaskName).Inc()
+		m.observeCompleted(event)
+	case task.EventType_Failed:
+		m.failed.WithLabelValues(event.TaskName).Inc()
+		m.observeCompleted(event)
+	case task.EventType_Retried:
+		m.retried.WithLabelValues(event.TaskName).Inc()
+		m.observeCompleted(event)
+	case task.EventType_DeadLettered:
+		m.deadLetter.WithLabelValues(event.TaskName).Inc()
+	}
+}
+
+func (m *TaskMetrics) observeCompleted(event *task.Event) {
+	m.inProgress.WithLabelValues(event.TaskName).Dec()
+	m.duration.WithLabelValues(event.TaskName, string(event.Type)).Observe(event.Duration.Seconds())
+}
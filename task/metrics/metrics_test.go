@@ -0,0 +1,57 @@
+package metrics_test
+
+import (
+	"errors"
+	"github.com/kintohub/utils-go/task"
+	"github.com/kintohub/utils-go/task/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskMetrics_HandleEvent(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.NewTaskMetrics("kinto", registry)
+	assert.NoError(t, err)
+
+	events := []*task.Event{
+		{Type: task.EventType_Submitted, TaskName: "helloworld"},
+		{Type: task.EventType_Started, TaskName: "helloworld"},
+		{Type: task.EventType_Retried, TaskName: "helloworld", Duration: time.Second, Error: errors.New("boom")},
+		{Type: task.EventType_Started, TaskName: "helloworld"},
+		{Type: task.EventType_Succeeded, TaskName: "helloworld", Duration: time.Second},
+		{Type: task.EventType_Started, TaskName: "chaintask"},
+		{Type: task.EventType_Failed, TaskName: "chaintask", Duration: time.Second, Error: errors.New("boom")},
+	}
+
+	for _, event := range events {
+		m.HandleEvent(event)
+	}
+
+	expected := `
+# HELP kinto_task_started_total Total number of task executions started by the workers.
+# TYPE kinto_task_started_total counter
+kinto_task_started_total{task="chaintask"} 1
+kinto_task_started_total{task="helloworld"} 2
+# HELP kinto_task_retried_total Total number of task executions that failed and were retried.
+# TYPE kinto_task_retried_total counter
+kinto_task_retried_total{task="helloworld"} 1
+# HELP kinto_task_failed_total Total number of tasks that failed and will not be retried.
+# TYPE kinto_task_failed_total counter
+kinto_task_failed_total{task="chaintask"} 1
+# HELP kinto_task_in_progress Number of tasks currently being processed by the workers.
+# TYPE kinto_task_in_progress gauge
+kinto_task_in_progress{task="chaintask"} 0
+kinto_task_in_progress{task="helloworld"} 0
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"kinto_task_started_total", "kinto_task_retried_total", "kinto_task_failed_total", "kinto_task_in_progress"))
+
+	// one histogram per task name and result
+	count, err := testutil.GatherAndCount(registry, "kinto_task_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
@@ -1,5 +1,7 @@
 package task
 
+import "context"
+
 type TaskHandler func(json string) error
 type ChainTaskHandler func(json string) (*Task, error)
 
@@ -11,3 +13,9 @@ type TaskClientInterface interface {
 	// Register a task handler that will return a follow up task after processing its task
 	RegisterChainTaskHandler(taskName string, chainTaskHandler ChainTaskHandler) error
 }
+
+// Implemented by the task clients able to report their health, ex: the machinery client
+type HealthChecker interface {
+	// Report whether the broker is reachable and the worker(s) are still consuming tasks
+	Health(ctx context.Context) *HealthStatus
+}
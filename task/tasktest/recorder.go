@@ -0,0 +1,224 @@
+// Package tasktest provides a fake task client to unit test code that submits or handles tasks
+package tasktest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kintohub/utils-go/task"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// Records every submitted task instead of sending it to a broker. Pending tasks can be executed
+// synchronously with their registered handlers by calling Drain, or on every submission
+// when created with NewDrainingRecorder
+type Recorder struct {
+	mutex sync.Mutex
+	// held while draining so concurrent Drain calls wait for each other
+	drainMutex sync.Mutex
+	handlers   map[string]task.ChainTaskHandler
+	submitted  []*task.Task
+	pending    []*task.Task
+	autoDrain  bool
+	draining   bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		handlers: map[string]task.ChainTaskHandler{},
+	}
+}
+
+// Creates a recorder that drains pending tasks as soon as they are submitted. Follow up tasks
+// returned by chain handlers are queued and executed in submission order before SubmitTask returns
+func NewDrainingRecorder() *Recorder {
+	r := NewRecorder()
+	r.autoDrain = true
+	return r
+}
+
+// Tasks submitted while a drain is running, ex: by handlers, are executed by that drain
+func (r *Recorder) SubmitTask(t *task.Task) error {
+	r.mutex.Lock()
+	r.submitted = append(r.submitted, t)
+	r.pending = append(r.pending, t)
+	autoDrain := r.autoDrain && !r.draining
+	r.mutex.Unlock()
+
+	if autoDrain {
+		return r.Drain()
+	}
+
+	return nil
+}
+
+func (r *Recorder) RegisterTaskHandler(taskName string, taskHandler task.TaskHandler) error {
+	return r.RegisterChainTaskHandler(taskName, func(json string) (*task.Task, error) {
+		return nil, taskHandler(json)
+	})
+}
+
+func (r *Recorder) RegisterChainTaskHandler(taskName string, chainTaskHandler task.ChainTaskHandler) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.handlers[taskName]; ok {
+		return fmt.Errorf("a handler is already registered for task %s", taskName)
+	}
+
+	r.handlers[taskName] = chainTaskHandler
+	return nil
+}
+
+// Executes pending tasks in submission order until none are left, including follow up tasks returned
+// by chain handlers. Stops at the first task without a registered handler or handler error and returns it,
+// the failing task is not retried. Waits for the drain running in another goroutine, if any, to complete first.
+// Must not be called from handlers, submit tasks instead
+func (r *Recorder) Drain() error {
+	r.drainMutex.Lock()
+	defer r.drainMutex.Unlock()
+
+	r.mutex.Lock()
+	r.draining = true
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		r.draining = false
+		r.mutex.Unlock()
+	}()
+
+	for {
+		t, handler := r.nextPendingTask()
+
+		if t == nil {
+			return nil
+		}
+
+		if handler == nil {
+			return fmt.Errorf("no handler is registered for task %s", t.Name)
+		}
+
+		nextTask, err := handler(t.Data)
+
+		if err != nil {
+			return fmt.Errorf("task %s failed: %w", t.Name, err)
+		}
+
+		if nextTask != nil {
+			if nextTask.RequestId == "" {
+				nextTask.RequestId = t.RequestId
+			}
+
+			if err := r.SubmitTask(nextTask); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Recorder) nextPendingTask() (*task.Task, task.ChainTaskHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.pending) == 0 {
+		return nil, nil
+	}
+
+	t := r.pending[0]
+	r.pending = r.pending[1:]
+
+	return t, r.handlers[t.Name]
+}
+
+// The recorder has no broker and executes handlers in process, so it is always healthy
+func (r *Recorder) Health(ctx context.Context) *task.HealthStatus {
+	return &task.HealthStatus{
+		BrokerReachable: true,
+		WorkersEnabled:  true,
+		WorkerConsuming: true,
+	}
+}
+
+// Returns all tasks submitted so far in submission order
+func (r *Recorder) SubmittedTasks() []*task.Task {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]*task.Task{}, r.submitted...)
+}
+
+// Returns the submitted tasks named taskName in submission order
+func (r *Recorder) SubmittedTasksByName(taskName string) []*task.Task {
+	var result []*task.Task
+
+	for _, t := range r.SubmittedTasks() {
+		if t.Name == taskName {
+			result = append(result, t)
+		}
+	}
+
+	return result
+}
+
+// Clears the submitted and pending tasks. Registered handlers are kept
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.submitted = nil
+	r.pending = nil
+}
+
+// Asserts that taskName was submitted exactly times times
+func (r *Recorder) AssertSubmittedTimes(t testing.TB, taskName string, times int) bool {
+	t.Helper()
+	return assert.Len(t, r.SubmittedTasksByName(taskName), times,
+		"unexpected number of submitted %s tasks", taskName)
+}
+
+func (r *Recorder) AssertNotSubmitted(t testing.TB, taskName string) bool {
+	t.Helper()
+	return r.AssertSubmittedTimes(t, taskName, 0)
+}
+
+// Asserts that taskName was submitted exactly once and that its payload is the json encoding of payload
+func (r *Recorder) AssertSubmittedOnceWith(t testing.TB, taskName string, payload interface{}) bool {
+	t.Helper()
+
+	if !r.AssertSubmittedTimes(t, taskName, 1) {
+		return false
+	}
+
+	expected, err := json.Marshal(payload)
+	if !assert.NoError(t, err, "could not encode the expected payload") {
+		return false
+	}
+
+	return assert.JSONEq(t, string(expected), r.SubmittedTasksByName(taskName)[0].Data)
+}
+
+// Decodes the payload of t into v
+func DecodeTask(t *task.Task, v interface{}) error {
+	return json.Unmarshal([]byte(t.Data), v)
+}
+
+// Decodes the payloads of all submitted tasks named taskName. newPayload must return a pointer
+// to a new struct of the payload type, ex: func() interface{} { return new(HelloWorldTask) }
+func (r *Recorder) DecodeSubmittedTasks(taskName string, newPayload func() interface{}) ([]interface{}, error) {
+	var payloads []interface{}
+
+	for _, t := range r.SubmittedTasksByName(taskName) {
+		payload := newPayload()
+
+		if err := DecodeTask(t, payload); err != nil {
+			return nil, fmt.Errorf("could not decode %s task: %w", taskName, err)
+		}
+
+		payloads = append(payloads, payload)
+	}
+
+	return payloads, nil
+}
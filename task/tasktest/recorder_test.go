@@ -0,0 +1,130 @@
+package tasktest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kintohub/utils-go/task"
+	"github.com/kintohub/utils-go/task/tasktest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var _ task.TaskClientInterface = tasktest.NewRecorder()
+
+type helloWorldTask struct {
+	Msg string `json:"msg"`
+}
+
+func submit(t *testing.T, client task.TaskClientInterface, name, msg string) {
+	newTask, err := task.NewTask(name, &helloWorldTask{Msg: msg})
+	assert.NoError(t, err)
+	assert.NoError(t, client.SubmitTask(newTask))
+}
+
+func TestRecorder_AssertSubmitted(t *testing.T) {
+	r := tasktest.NewRecorder()
+	submit(t, r, "helloworld", "yo")
+
+	r.AssertSubmittedOnceWith(t, "helloworld", &helloWorldTask{Msg: "yo"})
+	r.AssertNotSubmitted(t, "chaintask")
+
+	payloads, err := r.DecodeSubmittedTasks("helloworld", func() interface{} { return new(helloWorldTask) })
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{&helloWorldTask{Msg: "yo"}}, payloads)
+}
+
+func TestRecorder_Drain(t *testing.T) {
+	r := tasktest.NewRecorder()
+	var received []string
+
+	err := r.RegisterTaskHandler("helloworld", func(data string) error {
+		h := new(helloWorldTask)
+		if err := json.Unmarshal([]byte(data), h); err != nil {
+			return err
+		}
+		received = append(received, h.Msg)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = r.RegisterChainTaskHandler("chaintask", func(data string) (*task.Task, error) {
+		received = append(received, "chain")
+		return task.NewTask("helloworld", &helloWorldTask{Msg: "hello again!"})
+	})
+	assert.NoError(t, err)
+
+	submit(t, r, "chaintask", "yo")
+	submit(t, r, "helloworld", "first")
+	assert.Empty(t, received)
+
+	assert.NoError(t, r.Drain())
+	assert.Equal(t, []string{"chain", "first", "hello again!"}, received)
+	r.AssertSubmittedTimes(t, "helloworld", 2)
+}
+
+func TestRecorder_DrainUnknownTask(t *testing.T) {
+	r := tasktest.NewRecorder()
+	submit(t, r, "unhandled", "yo")
+
+	assert.EqualError(t, r.Drain(), "no handler is registered for task unhandled")
+	r.AssertSubmittedTimes(t, "unhandled", 1)
+}
+
+func TestRecorder_ConcurrentDrain(t *testing.T) {
+	r := tasktest.NewRecorder()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []string
+
+	assert.NoError(t, r.RegisterTaskHandler("blocking", func(data string) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	assert.NoError(t, r.RegisterTaskHandler("helloworld", func(data string) error {
+		received = append(received, "hello")
+		return nil
+	}))
+
+	submit(t, r, "blocking", "yo")
+	firstDrain := make(chan error)
+	go func() {
+		firstDrain <- r.Drain()
+	}()
+	<-started
+
+	submit(t, r, "helloworld", "yo")
+	secondDrain := make(chan error)
+	go func() {
+		secondDrain <- r.Drain()
+	}()
+
+	// the second drain waits for the first one rather than returning right away
+	select {
+	case <-secondDrain:
+		t.Fatal("the second drain returned while the first one was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-firstDrain)
+	assert.NoError(t, <-secondDrain)
+	assert.Equal(t, []string{"hello"}, received)
+}
+
+func TestDrainingRecorder_HandlerError(t *testing.T) {
+	r := tasktest.NewDrainingRecorder()
+	handlerErr := errors.New("boom")
+
+	assert.NoError(t, r.RegisterTaskHandler("helloworld", func(data string) error {
+		return handlerErr
+	}))
+
+	newTask, err := task.NewTask("helloworld", &helloWorldTask{Msg: "yo"})
+	assert.NoError(t, err)
+
+	err = r.SubmitTask(newTask)
+	assert.True(t, errors.Is(err, handlerErr))
+	r.AssertSubmittedTimes(t, "helloworld", 1)
+}
@@ -5,6 +5,9 @@ import "encoding/json"
 type Task struct {
 	Name string `json:"name"`
 	Data string `json:"data"`
+	// Optional id of the request that caused the task, carried along for auditing.
+	// Follow up tasks of a chain inherit it when left empty
+	RequestId string `json:"requestId,omitempty"`
 }
 
 func NewTask(name string, data interface{}) (*Task, error) {